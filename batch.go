@@ -0,0 +1,204 @@
+package hamt
+
+import (
+	"context"
+	"sort"
+
+	"github.com/quorumcontrol/go-hamt-ipld/pb"
+)
+
+// Op is a single change made by ApplyBatch: Key is set to Value, or removed
+// if Delete is set.
+type Op struct {
+	Key    string
+	Value  interface{}
+	Delete bool
+}
+
+type batchOp struct {
+	key  string
+	hash []byte
+	// kv is nil for deletions
+	kv     *pb.KV
+	result *error
+}
+
+func (op *batchOp) setResult(err error) {
+	if op.result != nil {
+		*op.result = err
+	}
+}
+
+// ApplyBatch applies ops to the HAMT as if they had been made one after the
+// other, and returns the outcome of each: nil, or ErrNotFound for a deletion
+// of a key that isn't set at that point in the batch. The ops are grouped by
+// hash path so that every node they touch is modified once, and subshards
+// split off along the way are only written to the store by the single Flush
+// that ends the batch.
+//
+// An error is returned if a value can't be encoded, in which case the HAMT
+// is left unchanged, or if a node can't be loaded or stored, in which case
+// only some of the ops may have been applied.
+func (n *Node) ApplyBatch(ctx context.Context, ops []Op) ([]error, error) {
+	results := make([]error, len(ops))
+	bops := make([]*batchOp, len(ops))
+	for i, op := range ops {
+		bop := &batchOp{key: op.Key, hash: n.hashKey(op.Key), result: &results[i]}
+		if !op.Delete {
+			kv, err := n.encodeKV(ctx, op.Key, op.Value)
+			if err != nil {
+				return nil, err
+			}
+			bop.kv = kv
+		}
+		bops[i] = bop
+	}
+
+	if err := n.applyBatch(ctx, bops); err != nil {
+		return nil, err
+	}
+	if err := n.Flush(ctx); err != nil {
+		return nil, err
+	}
+	return results, nil
+}
+
+func (n *Node) applyBatch(ctx context.Context, ops []*batchOp) error {
+	n.opMu.RLock()
+	defer n.opMu.RUnlock()
+
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return n.applyOps(ctx, ops, 0)
+}
+
+// applyOps applies ops, whose hashes all lead to n after the first consumed
+// bits, to the subtree rooted at n. It is called with n.mu held for writing.
+func (n *Node) applyOps(ctx context.Context, ops []*batchOp, consumed int) error {
+	bitWidth := n.conf().bitWidth
+
+	groups := make(map[int][]*batchOp)
+	var order []int
+	for _, op := range ops {
+		idx, err := (&hashBits{b: op.hash, consumed: consumed}).Next(bitWidth)
+		if err != nil {
+			return err
+		}
+		if _, ok := groups[idx]; !ok {
+			order = append(order, idx)
+		}
+		groups[idx] = append(groups[idx], op)
+	}
+	sort.Ints(order)
+
+	for _, idx := range order {
+		if err := n.applyGroup(ctx, groups[idx], idx, consumed+bitWidth); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// applyGroup applies the ops that are stored under bit idx of n.
+func (n *Node) applyGroup(ctx context.Context, ops []*batchOp, idx int, consumed int) error {
+	var kvs []*pb.KV
+	if n.Bitfield.Bit(idx) == 1 {
+		cindex := byte(n.indexForBitPos(idx))
+		child := n.getChild(cindex)
+		if child == nil {
+			return ErrMalformedNode
+		}
+		if child.isShard() {
+			chnd, err := child.loadChild(ctx, n)
+			if err != nil {
+				return err
+			}
+			if chnd.owner != n.owner {
+				chnd = chnd.cloneFor(n.owner)
+				child.cache = chnd
+			}
+
+			chnd.mu.Lock()
+			defer chnd.mu.Unlock()
+			before := chnd.count
+			if err := chnd.applyOps(ctx, ops, consumed); err != nil {
+				return err
+			}
+			n.addCount(int(int64(chnd.count) - int64(before)))
+			return n.cleanChild(chnd, cindex, idx)
+		}
+		kvs = child.Kvs
+	}
+
+	old := len(kvs)
+	kvs = mergeBucket(kvs, ops)
+	n.addCount(len(kvs) - old)
+	if len(kvs) == 0 {
+		if n.Bitfield.Bit(idx) == 1 {
+			return n.rmChild(byte(n.indexForBitPos(idx)), idx)
+		}
+		return nil
+	}
+
+	// split an overflowing bucket, unless its keys collide completely
+	hv := &hashBits{b: ops[0].hash, consumed: consumed}
+	if len(kvs) > n.conf().bucketSize && hv.hasNext(n.conf().bitWidth) {
+		sub := newNode(n.store, n.config)
+		sub.owner = n.owner
+		sub.depth = n.depth + 1
+		subOps := make([]*batchOp, len(kvs))
+		for i, kv := range kvs {
+			subOps[i] = &batchOp{key: kv.Key, hash: n.hashKey(kv.Key), kv: kv}
+		}
+		if err := sub.applyOps(ctx, subOps, consumed); err != nil {
+			return err
+		}
+		return n.putPointer(idx, &Pointer{Pointer: new(pb.Pointer), cache: sub})
+	}
+
+	return n.putPointer(idx, &Pointer{Pointer: &pb.Pointer{Kvs: kvs}})
+}
+
+// mergeBucket returns a new bucket holding the pairs of kvs with ops applied
+// in order, sorted by key.
+func mergeBucket(kvs []*pb.KV, ops []*batchOp) []*pb.KV {
+	pairs := make(map[string]*pb.KV, len(kvs)+len(ops))
+	for _, kv := range kvs {
+		pairs[kv.Key] = kv
+	}
+
+	for _, op := range ops {
+		if op.kv == nil {
+			if _, ok := pairs[op.key]; !ok {
+				op.setResult(ErrNotFound)
+				continue
+			}
+			delete(pairs, op.key)
+			op.setResult(nil)
+			continue
+		}
+		pairs[op.key] = op.kv
+		op.setResult(nil)
+	}
+
+	out := make([]*pb.KV, 0, len(pairs))
+	for _, kv := range pairs {
+		out = append(out, kv)
+	}
+	sort.Slice(out, func(i, j int) bool {
+		return out[i].Key < out[j].Key
+	})
+	return out
+}
+
+// putPointer stores p under bit idx, replacing the pointer already there.
+func (n *Node) putPointer(idx int, p *Pointer) error {
+	i := n.indexForBitPos(idx)
+	if n.Bitfield.Bit(idx) == 1 {
+		return n.setChild(byte(i), p)
+	}
+
+	n.Bitfield.SetBit(n.Bitfield, idx, 1)
+	n.Pointers = append(n.Pointers[:i], append([]*Pointer{p}, n.Pointers[i:]...)...)
+	return nil
+}
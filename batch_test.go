@@ -0,0 +1,126 @@
+package hamt
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"sync/atomic"
+	"testing"
+
+	format "github.com/ipfs/go-ipld-format"
+)
+
+type countingNodes struct {
+	nodes
+	adds int64
+}
+
+func (cn *countingNodes) Add(ctx context.Context, nd format.Node) error {
+	atomic.AddInt64(&cn.adds, 1)
+	return cn.nodes.Add(ctx, nd)
+}
+
+func TestApplyBatchMatchesSequential(t *testing.T) {
+	ctx := context.Background()
+	cs := NewCborStore()
+
+	for _, opts := range [][]Option{
+		nil,
+		{UseTreeBitWidth(3)},
+		{UseTreeBitWidth(5), UseBucketSize(1)},
+		{UseHasher(shortIdentityHash)},
+	} {
+		seq := NewNode(cs, opts...)
+		batched := NewNode(cs, opts...)
+
+		r := rand.New(rand.NewSource(1))
+		for round := 0; round < 5; round++ {
+			ops := make([]Op, 1000)
+			for i := range ops {
+				k := fmt.Sprintf("key%d", r.Intn(1500))
+				if r.Intn(3) == 0 {
+					ops[i] = Op{Key: k, Delete: true}
+				} else {
+					ops[i] = Op{Key: k, Value: r.Int()}
+				}
+			}
+
+			results, err := batched.ApplyBatch(ctx, ops)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for i, op := range ops {
+				var err error
+				if op.Delete {
+					err = seq.Delete(ctx, op.Key)
+				} else {
+					err = seq.Set(ctx, op.Key, op.Value)
+				}
+				if err != results[i] {
+					t.Fatalf("op %d: expected %v, got %v", i, err, results[i])
+				}
+			}
+
+			if !nodesEqual(t, cs, seq, batched) {
+				t.Fatal("batch produced a different tree than applying the ops one by one")
+			}
+		}
+	}
+}
+
+func TestApplyBatchWritesOnlyFinalNodes(t *testing.T) {
+	ctx := context.Background()
+
+	var ops []Op
+	for i := 0; i < 3000; i++ {
+		ops = append(ops, Op{Key: fmt.Sprintf("key%d", i), Value: i})
+	}
+
+	seqStore := &countingNodes{nodes: MustMemoryStore()}
+	seq := NewNode(&CborIpldStore{Nodes: seqStore}, UseTreeBitWidth(4))
+	for _, op := range ops {
+		if err := seq.Set(ctx, op.Key, op.Value); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := seq.Flush(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	batchStore := &countingNodes{nodes: MustMemoryStore()}
+	batched := NewNode(&CborIpldStore{Nodes: batchStore}, UseTreeBitWidth(4))
+	if _, err := batched.ApplyBatch(ctx, ops); err != nil {
+		t.Fatal(err)
+	}
+
+	st, err := batched.Stats(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if int(batchStore.adds) != st.Nodes-1 {
+		t.Fatalf("expected one write per non-root node (%d), got %d", st.Nodes-1, batchStore.adds)
+	}
+	if batchStore.adds >= seqStore.adds {
+		t.Fatalf("expected the batch to write fewer blocks than sequential sets (%d >= %d)", batchStore.adds, seqStore.adds)
+	}
+}
+
+func TestApplyBatchEncodingError(t *testing.T) {
+	ctx := context.Background()
+	cs := NewCborStore()
+
+	n := NewNode(cs)
+	if err := n.Set(ctx, "a", 1); err != nil {
+		t.Fatal(err)
+	}
+	_, err := n.ApplyBatch(ctx, []Op{
+		{Key: "b", Value: 2},
+		{Key: "c", Value: struct{ A int }{1}},
+	})
+	if err == nil {
+		t.Fatal("expected an error encoding a type without an atlas entry")
+	}
+	if _, err := n.Find(ctx, "b"); err != ErrNotFound {
+		t.Fatal("a failed batch should not apply any op")
+	}
+}
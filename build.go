@@ -0,0 +1,466 @@
+package hamt
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"sort"
+
+	"github.com/quorumcontrol/go-hamt-ipld/pb"
+)
+
+// defaultBuildMemoryLimit is the number of pairs Build holds in memory when
+// no BuildMemoryLimit option is given.
+const defaultBuildMemoryLimit = 1 << 20
+
+// BuildOption configures Build.
+type BuildOption func(*buildConfig)
+
+type buildConfig struct {
+	memoryLimit int
+}
+
+// BuildMemoryLimit sets the number of pairs Build holds in memory at once.
+// Larger inputs are spilled to temporary files and partitioned by hash until
+// every part fits. entries must be at least 1.
+func BuildMemoryLimit(entries int) BuildOption {
+	if entries < 1 {
+		panic(fmt.Sprintf("invalid HAMT build memory limit %d", entries))
+	}
+	return func(c *buildConfig) {
+		c.memoryLimit = entries
+	}
+}
+
+// KVSource supplies the pairs that Build creates a HAMT from.
+type KVSource interface {
+	// Next returns the next pair, with its value encoded the way Set
+	// encodes values, or io.EOF when there are none left.
+	Next() (*pb.KV, error)
+}
+
+// Build creates a HAMT holding every pair from src. When a key appears more
+// than once the last value wins, as if the pairs had been Set in order. The
+// result is identical to the one incremental insertion gives, but the tree
+// is built bottom up: every node below the root is written to cs exactly
+// once, and no intermediate subshards are created. The root is returned
+// without being written, like after a Flush. opts configure the HAMT as they
+// do for NewNode.
+func Build(ctx context.Context, cs *CborIpldStore, src KVSource, opts []Option, buildOpts ...BuildOption) (*Node, error) {
+	bconf := &buildConfig{memoryLimit: defaultBuildMemoryLimit}
+	for _, o := range buildOpts {
+		o(bconf)
+	}
+	b := &builder{ctx: ctx, store: cs, conf: newConfig(opts...), memoryLimit: bconf.memoryLimit}
+	defer b.cleanup()
+
+	var entries []*buildEntry
+	var spill *spillFile
+	for seq := uint64(0); ; seq++ {
+		kv, err := src.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		if spill != nil {
+			if err := spill.write(seq, kv); err != nil {
+				return nil, err
+			}
+			continue
+		}
+
+		entries = append(entries, b.entry(seq, kv))
+		if len(entries) > b.memoryLimit {
+			if spill, err = b.newSpillFile(); err != nil {
+				return nil, err
+			}
+			for _, e := range entries {
+				if err := spill.write(e.seq, e.kv); err != nil {
+					return nil, err
+				}
+			}
+			entries = nil
+		}
+	}
+
+	var root *Node
+	var err error
+	if spill != nil {
+		if err := spill.finish(); err != nil {
+			return nil, err
+		}
+		root, err = b.buildSpilledNode(spill, 0)
+		spill.remove()
+	} else {
+		root, err = b.buildNode(dedupe(entries), 0)
+	}
+	if err != nil {
+		return nil, err
+	}
+	root.bitWidth = b.conf.bitWidth
+	root.owner = newOwner()
+	return root, nil
+}
+
+type buildEntry struct {
+	seq  uint64
+	hash []byte
+	kv   *pb.KV
+}
+
+type builder struct {
+	ctx   context.Context
+	store *CborIpldStore
+	conf  *config
+	// memoryLimit is the number of pairs held in memory at once
+	memoryLimit int
+
+	hashLen int
+	// dir holds the spill files, it is only created once needed
+	dir string
+}
+
+func (b *builder) entry(seq uint64, kv *pb.KV) *buildEntry {
+	e := &buildEntry{seq: seq, hash: b.conf.hasher.Hash(kv.Key), kv: kv}
+	b.hashLen = len(e.hash)
+	return e
+}
+
+// hasLevel reports whether the hashes have enough bits left for a node
+// consumed bits deep.
+func (b *builder) hasLevel(consumed int) bool {
+	return consumed+b.conf.bitWidth <= b.hashLen*8
+}
+
+func (b *builder) newNode() *Node {
+	return newNode(b.store, b.conf)
+}
+
+// buildNode returns the node holding entries, which have already been
+// deduplicated, at consumed bits deep. Its children are written to the
+// store, the node itself isn't.
+func (b *builder) buildNode(entries []*buildEntry, consumed int) (*Node, error) {
+	groups := make(map[int][]*buildEntry)
+	var order []int
+	for _, e := range entries {
+		idx, err := (&hashBits{b: e.hash, consumed: consumed}).Next(b.conf.bitWidth)
+		if err != nil {
+			return nil, err
+		}
+		if _, ok := groups[idx]; !ok {
+			order = append(order, idx)
+		}
+		groups[idx] = append(groups[idx], e)
+	}
+	sort.Ints(order)
+
+	nd := b.newNode()
+	nd.count = uint64(len(entries))
+	for _, idx := range order {
+		p, err := b.buildPointer(groups[idx], consumed+b.conf.bitWidth)
+		if err != nil {
+			return nil, err
+		}
+		if err := nd.putPointer(idx, p); err != nil {
+			return nil, err
+		}
+	}
+	return nd, nil
+}
+
+// buildPointer returns either a bucket or a link to a subshard holding
+// entries, at consumed bits deep.
+func (b *builder) buildPointer(entries []*buildEntry, consumed int) (*Pointer, error) {
+	// like in modifyBucket, keys that collide completely stay in a bucket
+	if len(entries) <= b.conf.bucketSize || !b.hasLevel(consumed) {
+		kvs := make([]*pb.KV, len(entries))
+		for i, e := range entries {
+			kv, err := externalize(b.ctx, b.store, b.conf, e.kv)
+			if err != nil {
+				return nil, err
+			}
+			kvs[i] = kv
+		}
+		return bucketPointer(kvs), nil
+	}
+
+	child, err := b.buildNode(entries, consumed)
+	if err != nil {
+		return nil, err
+	}
+	return b.linkPointer(child)
+}
+
+func (b *builder) linkPointer(child *Node) (*Pointer, error) {
+	c, err := b.store.Put(b.ctx, child)
+	if err != nil {
+		return nil, err
+	}
+	p := new(pb.Pointer)
+	p.SetLink(c)
+	return &Pointer{Pointer: p}, nil
+}
+
+// buildSpilledNode is buildNode for entries that don't fit in memory. They
+// are partitioned by the bits used at this level, and every part is built on
+// its own.
+func (b *builder) buildSpilledNode(sp *spillFile, consumed int) (*Node, error) {
+	parts, err := b.partition(sp, consumed)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		for _, part := range parts {
+			part.remove()
+		}
+	}()
+
+	order := make([]int, 0, len(parts))
+	for idx := range parts {
+		order = append(order, idx)
+	}
+	sort.Ints(order)
+
+	nd := b.newNode()
+	for _, idx := range order {
+		part := parts[idx]
+		childConsumed := consumed + b.conf.bitWidth
+
+		var p *Pointer
+		if part.count <= b.memoryLimit || !b.hasLevel(childConsumed) {
+			entries, err := b.load(part)
+			if err != nil {
+				return nil, err
+			}
+			p, err = b.buildPointer(entries, childConsumed)
+			if err != nil {
+				return nil, err
+			}
+			nd.count += uint64(len(entries))
+		} else {
+			child, err := b.buildSpilledNode(part, childConsumed)
+			if err != nil {
+				return nil, err
+			}
+			nd.count += child.count
+			// duplicate keys are only dropped once a part fits in memory,
+			// so the child may turn out small enough to be a bucket
+			if kvs, ok := b.collapse(child); ok {
+				p = bucketPointer(kvs)
+			} else if p, err = b.linkPointer(child); err != nil {
+				return nil, err
+			}
+		}
+
+		if err := nd.putPointer(idx, p); err != nil {
+			return nil, err
+		}
+		part.remove()
+		delete(parts, idx)
+	}
+	return nd, nil
+}
+
+// collapse returns the pairs of child if it is small enough to be stored as
+// a bucket in its parent, following the same rules as cleanChild.
+func (b *builder) collapse(child *Node) ([]*pb.KV, bool) {
+	var kvs []*pb.KV
+	for _, p := range child.Pointers {
+		if p.isShard() {
+			return nil, false
+		}
+		kvs = append(kvs, p.Kvs...)
+	}
+	if len(kvs) > b.conf.bucketSize {
+		return nil, false
+	}
+	return kvs, true
+}
+
+// partition splits the entries in sp by the bits used at consumed bits deep.
+func (b *builder) partition(sp *spillFile, consumed int) (map[int]*spillFile, error) {
+	parts := make(map[int]*spillFile)
+	err := sp.each(func(seq uint64, kv *pb.KV) error {
+		hv := &hashBits{b: b.conf.hasher.Hash(kv.Key), consumed: consumed}
+		idx, err := hv.Next(b.conf.bitWidth)
+		if err != nil {
+			return err
+		}
+		part, ok := parts[idx]
+		if !ok {
+			if part, err = b.newSpillFile(); err != nil {
+				return err
+			}
+			parts[idx] = part
+		}
+		return part.write(seq, kv)
+	})
+	for _, part := range parts {
+		if ferr := part.finish(); err == nil {
+			err = ferr
+		}
+	}
+	if err != nil {
+		for _, part := range parts {
+			part.remove()
+		}
+		return nil, err
+	}
+	return parts, nil
+}
+
+// load reads the entries of sp into memory, dropping duplicate keys.
+func (b *builder) load(sp *spillFile) ([]*buildEntry, error) {
+	entries := make([]*buildEntry, 0, sp.count)
+	err := sp.each(func(seq uint64, kv *pb.KV) error {
+		entries = append(entries, b.entry(seq, kv))
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return dedupe(entries), nil
+}
+
+func (b *builder) newSpillFile() (*spillFile, error) {
+	if b.dir == "" {
+		dir, err := ioutil.TempDir("", "hamt-build")
+		if err != nil {
+			return nil, err
+		}
+		b.dir = dir
+	}
+	f, err := ioutil.TempFile(b.dir, "spill")
+	if err != nil {
+		return nil, err
+	}
+	return &spillFile{f: f, w: bufio.NewWriter(f)}, nil
+}
+
+func (b *builder) cleanup() {
+	if b.dir != "" {
+		os.RemoveAll(b.dir)
+	}
+}
+
+// dedupe keeps the last entry of every key. entries must be in source order.
+func dedupe(entries []*buildEntry) []*buildEntry {
+	last := make(map[string]int, len(entries))
+	for i, e := range entries {
+		last[e.kv.Key] = i
+	}
+	if len(last) == len(entries) {
+		return entries
+	}
+
+	out := make([]*buildEntry, 0, len(last))
+	for i, e := range entries {
+		if last[e.kv.Key] == i {
+			out = append(out, e)
+		}
+	}
+	return out
+}
+
+func bucketPointer(kvs []*pb.KV) *Pointer {
+	sort.Slice(kvs, func(i, j int) bool {
+		return kvs[i].Key < kvs[j].Key
+	})
+	return &Pointer{Pointer: &pb.Pointer{Kvs: kvs}}
+}
+
+// spillFile is a temporary file of entries, each stored as its sequence
+// number, key, value and codec. The sequence number orders duplicate keys.
+type spillFile struct {
+	f     *os.File
+	w     *bufio.Writer
+	count int
+}
+
+func (sp *spillFile) write(seq uint64, kv *pb.KV) error {
+	var buf [binary.MaxVarintLen64]byte
+	put := func(v uint64) error {
+		_, err := sp.w.Write(buf[:binary.PutUvarint(buf[:], v)])
+		return err
+	}
+
+	if err := put(seq); err != nil {
+		return err
+	}
+	if err := put(uint64(len(kv.Key))); err != nil {
+		return err
+	}
+	if _, err := sp.w.WriteString(kv.Key); err != nil {
+		return err
+	}
+	if err := put(uint64(len(kv.Value))); err != nil {
+		return err
+	}
+	if _, err := sp.w.Write(kv.Value); err != nil {
+		return err
+	}
+	if err := put(kv.Codec); err != nil {
+		return err
+	}
+	sp.count++
+	return nil
+}
+
+// finish flushes the entries written so far, after which the file can only
+// be read.
+func (sp *spillFile) finish() error {
+	return sp.w.Flush()
+}
+
+// each calls f with every entry in the file, in the order they were written.
+func (sp *spillFile) each(f func(seq uint64, kv *pb.KV) error) error {
+	if _, err := sp.f.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	r := bufio.NewReader(sp.f)
+
+	readBytes := func() ([]byte, error) {
+		l, err := binary.ReadUvarint(r)
+		if err != nil {
+			return nil, err
+		}
+		out := make([]byte, l)
+		_, err = io.ReadFull(r, out)
+		return out, err
+	}
+
+	for i := 0; i < sp.count; i++ {
+		seq, err := binary.ReadUvarint(r)
+		if err != nil {
+			return err
+		}
+		k, err := readBytes()
+		if err != nil {
+			return err
+		}
+		v, err := readBytes()
+		if err != nil {
+			return err
+		}
+		codec, err := binary.ReadUvarint(r)
+		if err != nil {
+			return err
+		}
+		if err := f(seq, &pb.KV{Key: string(k), Value: v, Codec: codec}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (sp *spillFile) remove() {
+	sp.f.Close()
+	os.Remove(sp.f.Name())
+}
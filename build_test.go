@@ -0,0 +1,107 @@
+package hamt
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"math/rand"
+	"testing"
+
+	"github.com/quorumcontrol/go-hamt-ipld/pb"
+)
+
+type sliceSource struct {
+	kvs []*pb.KV
+	err error
+}
+
+func (s *sliceSource) Next() (*pb.KV, error) {
+	if len(s.kvs) == 0 {
+		if s.err != nil {
+			return nil, s.err
+		}
+		return nil, io.EOF
+	}
+	kv := s.kvs[0]
+	s.kvs = s.kvs[1:]
+	return kv, nil
+}
+
+func randomKVs(t *testing.T, count int, keys int) []*pb.KV {
+	r := rand.New(rand.NewSource(int64(count)))
+	kvs := make([]*pb.KV, count)
+	for i := range kvs {
+		nd, err := WrapObject(r.Int())
+		if err != nil {
+			t.Fatal(err)
+		}
+		kvs[i] = &pb.KV{Key: fmt.Sprintf("key%d", r.Intn(keys)), Value: nd.RawData()}
+	}
+	return kvs
+}
+
+func TestBuildMatchesIncremental(t *testing.T) {
+	ctx := context.Background()
+
+	cases := []struct {
+		count, keys int
+		opts        []Option
+		memoryLimit int
+	}{
+		{0, 1, nil, 0},
+		{2, 10, nil, 0},
+		{5000, 4000, nil, 0},
+		{5000, 4000, []Option{UseTreeBitWidth(3)}, 0},
+		{5000, 100000, []Option{UseTreeBitWidth(4), UseBucketSize(1)}, 0},
+		{3000, 3000, []Option{UseTreeBitWidth(5)}, 100},
+		{3000, 20, []Option{UseTreeBitWidth(2)}, 10},
+		{500, 500, []Option{UseHasher(shortIdentityHash)}, 7},
+	}
+
+	for i, tc := range cases {
+		kvs := randomKVs(t, tc.count, tc.keys)
+
+		cs := NewCborStore()
+		incremental := NewNode(cs, tc.opts...)
+		for _, kv := range kvs {
+			if err := incremental.modify(ctx, &hashBits{b: incremental.hashKey(kv.Key)}, kv.Key, kv); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		counter := &countingNodes{nodes: MustMemoryStore()}
+		var buildOpts []BuildOption
+		if tc.memoryLimit > 0 {
+			buildOpts = append(buildOpts, BuildMemoryLimit(tc.memoryLimit))
+		}
+		built, err := Build(ctx, &CborIpldStore{Nodes: counter}, &sliceSource{kvs: kvs}, tc.opts, buildOpts...)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if !nodesEqual(t, cs, incremental, built) {
+			t.Fatalf("case %d: built HAMT differs from incremental insertion", i)
+		}
+		st, err := built.Stats(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if int(counter.adds) != st.Nodes-1 {
+			t.Fatalf("case %d: expected every node below the root to be written once (%d), got %d writes", i, st.Nodes-1, counter.adds)
+		}
+
+		if err := built.Set(ctx, "another", 1); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestBuildSourceError(t *testing.T) {
+	ctx := context.Background()
+
+	fail := fmt.Errorf("source failed")
+	src := &sliceSource{kvs: randomKVs(t, 50, 50), err: fail}
+	if _, err := Build(ctx, NewCborStore(), src, nil, BuildMemoryLimit(10)); err != fail {
+		t.Fatalf("expected the source error, got %v", err)
+	}
+}
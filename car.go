@@ -0,0 +1,236 @@
+package hamt
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+
+	blocks "github.com/ipfs/go-block-format"
+	cid "github.com/ipfs/go-cid"
+	cbor "github.com/ipfs/go-ipld-cbor"
+	format "github.com/ipfs/go-ipld-format"
+)
+
+// ErrCARHashMismatch is returned by ImportCAR for a block that doesn't match
+// its CID.
+var ErrCARHashMismatch = fmt.Errorf("CAR block does not match its CID")
+
+// carHeader is the header of a CARv1 file.
+type carHeader struct {
+	Roots   []cid.Cid `refmt:"roots"`
+	Version uint64    `refmt:"version"`
+}
+
+func init() {
+	cbor.RegisterCborType(carHeader{})
+}
+
+// DefaultCARMaxBlockSize is the largest block ImportCAR reads when no
+// other limit is set with CARMaxBlockSize.
+const DefaultCARMaxBlockSize = 16 << 20
+
+// maxCARHeaderSize is the largest CAR header ImportCAR reads.
+const maxCARHeaderSize = 32 << 10
+
+// CAROption configures ExportCAR and ImportCAR.
+type CAROption func(*carConfig)
+
+type carConfig struct {
+	followValueLinks bool
+	maxBlockSize     int
+}
+
+func newCARConfig(opts ...CAROption) *carConfig {
+	conf := &carConfig{maxBlockSize: DefaultCARMaxBlockSize}
+	for _, o := range opts {
+		o(conf)
+	}
+	return conf
+}
+
+// CARFollowValueLinks also exports the blocks linked from CBOR values stored
+// in the HAMT, and everything reachable from them.
+func CARFollowValueLinks() CAROption {
+	return func(c *carConfig) {
+		c.followValueLinks = true
+	}
+}
+
+// CARMaxBlockSize sets the size of the largest block, along with its CID,
+// that ImportCAR reads. Larger sections are rejected before they are read.
+// size must be at least 1.
+func CARMaxBlockSize(size int) CAROption {
+	if size < 1 {
+		panic(fmt.Sprintf("invalid CAR max block size %d", size))
+	}
+	return func(c *carConfig) {
+		c.maxBlockSize = size
+	}
+}
+
+// ExportCAR writes root and every block reachable from it in store to w, as
+// a CARv1 file with root as its only root. Blocks are written once each, in
+// the order a depth first walk reaches them, so every node comes before its
+// children. Values stored outside of their node are always exported.
+func ExportCAR(ctx context.Context, store *CborIpldStore, root cid.Cid, w io.Writer, opts ...CAROption) error {
+	conf := newCARConfig(opts...)
+
+	bw := bufio.NewWriter(w)
+	header, err := cbor.DumpObject(&carHeader{Roots: []cid.Cid{root}, Version: 1})
+	if err != nil {
+		return err
+	}
+	if err := writeCARSection(bw, header); err != nil {
+		return err
+	}
+
+	get := func(ctx context.Context, c cid.Cid) (blocks.Block, error) {
+		return store.Nodes.Get(ctx, c)
+	}
+	err = walkBlocks(ctx, get, []cid.Cid{root}, conf.followValueLinks, func(blk blocks.Block) error {
+		return writeCARSection(bw, blk.Cid().Bytes(), blk.RawData())
+	})
+	if err != nil {
+		return err
+	}
+	return bw.Flush()
+}
+
+// ImportCAR adds every block of the CARv1 file read from r to store, and
+// returns the roots listed in its header. Every block is checked against its
+// CID before it is added, and ErrCARHashMismatch returned for the first one
+// that doesn't match. Blocks before it have already been added by then.
+// Sections over the size limits are rejected, so a file from an untrusted
+// source can't make it allocate more than a block's worth of memory.
+func ImportCAR(ctx context.Context, store *CborIpldStore, r io.Reader, opts ...CAROption) ([]cid.Cid, error) {
+	conf := newCARConfig(opts...)
+	br := bufio.NewReader(r)
+	data, err := readCARSection(br, maxCARHeaderSize)
+	if err == io.EOF {
+		return nil, io.ErrUnexpectedEOF
+	}
+	if err != nil {
+		return nil, err
+	}
+	if len(data) == 0 {
+		return nil, fmt.Errorf("invalid CAR header: empty")
+	}
+	var header carHeader
+	if err := cbor.DecodeInto(data, &header); err != nil {
+		return nil, fmt.Errorf("invalid CAR header: %v", err)
+	}
+	if header.Version != 1 {
+		return nil, fmt.Errorf("unsupported CAR version %d", header.Version)
+	}
+
+	for {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		data, err := readCARSection(br, conf.maxBlockSize)
+		if err == io.EOF {
+			return header.Roots, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		c, l, err := readCid(data)
+		if err != nil {
+			return nil, err
+		}
+		blk, err := verifiedBlock(c, data[l:])
+		if err != nil {
+			return nil, err
+		}
+		nd, err := format.Decode(blk)
+		if err != nil {
+			return nil, err
+		}
+		if err := store.Nodes.Add(ctx, nd); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// verifiedBlock returns data as the block c, if it hashes to c.
+func verifiedBlock(c cid.Cid, data []byte) (blocks.Block, error) {
+	sum, err := c.Prefix().Sum(data)
+	if err != nil {
+		return nil, err
+	}
+	if !sum.Equals(c) {
+		return nil, ErrCARHashMismatch
+	}
+	return blocks.NewBlockWithCid(data, c)
+}
+
+// writeCARSection writes the concatenation of parts, prefixed with its
+// length.
+func writeCARSection(w *bufio.Writer, parts ...[]byte) error {
+	l := 0
+	for _, p := range parts {
+		l += len(p)
+	}
+	var buf [binary.MaxVarintLen64]byte
+	if _, err := w.Write(buf[:binary.PutUvarint(buf[:], uint64(l))]); err != nil {
+		return err
+	}
+	for _, p := range parts {
+		if _, err := w.Write(p); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// readCARSection reads a section written by writeCARSection, of at most max
+// bytes. It returns io.EOF only if r ends before the section starts.
+func readCARSection(r *bufio.Reader, max int) ([]byte, error) {
+	l, err := binary.ReadUvarint(r)
+	if err == io.EOF {
+		return nil, io.EOF
+	}
+	if err != nil {
+		return nil, err
+	}
+	if l > uint64(max) {
+		return nil, fmt.Errorf("CAR section of %d bytes over the limit of %d", l, max)
+	}
+	data := make([]byte, l)
+	if _, err := io.ReadFull(r, data); err != nil {
+		if err == io.EOF {
+			return nil, io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+	return data, nil
+}
+
+// readCid returns the CID data starts with and its length in bytes.
+func readCid(data []byte) (cid.Cid, int, error) {
+	// a CIDv0 is a bare sha2-256 multihash
+	if len(data) >= 34 && data[0] == 0x12 && data[1] == 0x20 {
+		c, err := cid.Cast(data[:34])
+		return c, 34, err
+	}
+
+	l := 0
+	var fields [4]uint64 // version, codec, hash function, digest length
+	for i := range fields {
+		v, n := binary.Uvarint(data[l:])
+		if n <= 0 {
+			return cid.Undef, 0, fmt.Errorf("invalid CID in CAR block")
+		}
+		fields[i] = v
+		l += n
+	}
+	if fields[3] > uint64(len(data)-l) {
+		return cid.Undef, 0, fmt.Errorf("invalid CID in CAR block")
+	}
+	l += int(fields[3])
+	c, err := cid.Cast(data[:l])
+	return c, l, err
+}
@@ -0,0 +1,219 @@
+package hamt
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"testing"
+
+	cid "github.com/ipfs/go-cid"
+	"github.com/ipfs/go-merkledag"
+)
+
+func TestCARRoundTrip(t *testing.T) {
+	ctx := context.Background()
+	cs := NewCborStore()
+
+	linked := merkledag.NewRawNode([]byte("linked from a value"))
+	if err := cs.Nodes.Add(ctx, linked); err != nil {
+		t.Fatal(err)
+	}
+
+	n := NewNode(cs, UseTreeBitWidth(4), UseValueThreshold(100), UseValueChunkSize(64))
+	for i := 0; i < 1000; i++ {
+		if err := n.Set(ctx, fmt.Sprintf("key%d", i), i); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := n.SetRaw(ctx, "big", bytes.Repeat([]byte("big value "), 100)); err != nil {
+		t.Fatal(err)
+	}
+	if err := n.Set(ctx, "link", map[string]interface{}{"c": linked.Cid()}); err != nil {
+		t.Fatal(err)
+	}
+	if err := n.Flush(ctx); err != nil {
+		t.Fatal(err)
+	}
+	root, err := cs.Put(ctx, n)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, follow := range []bool{false, true} {
+		var opts []CAROption
+		if follow {
+			opts = append(opts, CARFollowValueLinks())
+		}
+		buf := new(bytes.Buffer)
+		if err := ExportCAR(ctx, cs, root, buf, opts...); err != nil {
+			t.Fatal(err)
+		}
+
+		imported := NewCborStore()
+		roots, err := ImportCAR(ctx, imported, buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(roots) != 1 || !roots[0].Equals(root) {
+			t.Fatalf("expected root %s, got %v", root, roots)
+		}
+
+		loaded, err := LoadNode(ctx, imported, root, UseTreeBitWidth(4))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !nodesEqual(t, cs, n, loaded) {
+			t.Fatal("imported HAMT differs from the exported one")
+		}
+		if _, err := loaded.FindRaw(ctx, "big"); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := imported.Nodes.Get(ctx, linked.Cid()); (err == nil) != follow {
+			t.Fatalf("expected the linked block to be exported only when following links, got %v with follow %t", err, follow)
+		}
+	}
+}
+
+func TestExportCARExternalValueLinks(t *testing.T) {
+	ctx := context.Background()
+	cs := NewCborStore()
+
+	linked := merkledag.NewRawNode([]byte("linked from a large value"))
+	if err := cs.Nodes.Add(ctx, linked); err != nil {
+		t.Fatal(err)
+	}
+	// the value is stored outside of the node, split over several chunks
+	n := NewNode(cs, UseValueThreshold(100), UseValueChunkSize(64))
+	v := map[string]interface{}{"c": linked.Cid(), "pad": make([]byte, 300)}
+	if err := n.Set(ctx, "link", v); err != nil {
+		t.Fatal(err)
+	}
+	if err := n.Flush(ctx); err != nil {
+		t.Fatal(err)
+	}
+	root, err := cs.Put(ctx, n)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	buf := new(bytes.Buffer)
+	if err := ExportCAR(ctx, cs, root, buf, CARFollowValueLinks()); err != nil {
+		t.Fatal(err)
+	}
+	imported := NewCborStore()
+	if _, err := ImportCAR(ctx, imported, buf); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := imported.Nodes.Get(ctx, linked.Cid()); err != nil {
+		t.Fatalf("expected the block linked from the value to be exported, got %v", err)
+	}
+	loaded, err := LoadNode(ctx, imported, root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var out map[string]interface{}
+	if err := loaded.FindInto(ctx, "link", &out); err != nil {
+		t.Fatal(err)
+	}
+	if c, ok := out["c"].(cid.Cid); !ok || !c.Equals(linked.Cid()) {
+		t.Fatalf("expected the value to link to %s, got %v", linked.Cid(), out["c"])
+	}
+}
+
+func TestImportCARTampered(t *testing.T) {
+	ctx := context.Background()
+	cs := NewCborStore()
+
+	n := NewNode(cs)
+	for i := 0; i < 100; i++ {
+		if err := n.Set(ctx, fmt.Sprintf("key%d", i), i); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := n.Flush(ctx); err != nil {
+		t.Fatal(err)
+	}
+	root, err := cs.Put(ctx, n)
+	if err != nil {
+		t.Fatal(err)
+	}
+	buf := new(bytes.Buffer)
+	if err := ExportCAR(ctx, cs, root, buf); err != nil {
+		t.Fatal(err)
+	}
+	car := buf.Bytes()
+
+	tampered := append([]byte(nil), car...)
+	tampered[len(tampered)-1] ^= 0xff
+	if _, err := ImportCAR(ctx, NewCborStore(), bytes.NewReader(tampered)); err != ErrCARHashMismatch {
+		t.Fatalf("expected ErrCARHashMismatch, got %v", err)
+	}
+
+	if _, err := ImportCAR(ctx, NewCborStore(), bytes.NewReader(car[:len(car)-10])); err != io.ErrUnexpectedEOF {
+		t.Fatalf("expected io.ErrUnexpectedEOF for a truncated file, got %v", err)
+	}
+}
+
+func TestImportCAROversized(t *testing.T) {
+	ctx := context.Background()
+	cs, n, _ := buildFlushedHamt(t, 100)
+	root, err := cs.Put(ctx, n)
+	if err != nil {
+		t.Fatal(err)
+	}
+	buf := new(bytes.Buffer)
+	if err := ExportCAR(ctx, n.store, root, buf); err != nil {
+		t.Fatal(err)
+	}
+	car := buf.Bytes()
+	hl, vl := binary.Uvarint(car)
+	header := car[:vl+int(hl)]
+
+	varint := func(v uint64) []byte {
+		b := make([]byte, binary.MaxVarintLen64)
+		return b[:binary.PutUvarint(b, v)]
+	}
+	cases := map[string][]byte{
+		"empty header":      varint(0),
+		"oversized header":  append(varint(1<<40), make([]byte, 10)...),
+		"oversized section": append(append([]byte(nil), header...), varint(1<<63)...),
+		"truncated section": append(append(append([]byte(nil), header...), varint(100)...), 1, 2, 3),
+	}
+	for name, data := range cases {
+		if _, err := ImportCAR(ctx, NewCborStore(), bytes.NewReader(data)); err == nil {
+			t.Fatalf("%s: expected an error", name)
+		}
+	}
+
+	if _, err := ImportCAR(ctx, NewCborStore(), bytes.NewReader(car), CARMaxBlockSize(10)); err == nil {
+		t.Fatal("expected blocks over the limit to be rejected")
+	}
+	if _, err := ImportCAR(ctx, NewCborStore(), bytes.NewReader(car), CARMaxBlockSize(len(car))); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestReadCid(t *testing.T) {
+	v1 := merkledag.NewRawNode([]byte("raw")).Cid()
+	v0 := merkledag.NodeWithData([]byte("pb")).Cid()
+	if v0.Version() != 0 {
+		t.Fatalf("expected a CIDv0, got %s", v0)
+	}
+
+	for _, c := range []cid.Cid{v0, v1} {
+		data := append(c.Bytes(), "block data"...)
+		got, l, err := readCid(data)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !got.Equals(c) || l != len(c.Bytes()) {
+			t.Fatalf("expected %s of %d bytes, got %s of %d", c, len(c.Bytes()), got, l)
+		}
+	}
+
+	if _, _, err := readCid(v1.Bytes()[:5]); err == nil {
+		t.Fatal("expected an error for a truncated CID")
+	}
+}
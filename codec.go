@@ -0,0 +1,208 @@
+package hamt
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"github.com/gogo/protobuf/proto"
+	ptypes "github.com/gogo/protobuf/types"
+	cid "github.com/ipfs/go-cid"
+	cbor "github.com/ipfs/go-ipld-cbor"
+	refmtcbor "github.com/polydawn/refmt/cbor"
+	atlas "github.com/polydawn/refmt/obj/atlas"
+	"github.com/quorumcontrol/go-hamt-ipld/goipldpb"
+	"github.com/quorumcontrol/go-hamt-ipld/pb"
+)
+
+// Codec encodes and decodes the values of a HAMT. Its code is stored with
+// every value, so that a value is never decoded with a different codec than
+// the one it was encoded with.
+type Codec interface {
+	// Code is the multicodec identifying the encoding.
+	Code() uint64
+	Encode(v interface{}) ([]byte, error)
+	// Decode decodes data into out, which is a pointer.
+	Decode(data []byte, out interface{}) error
+}
+
+// multicodecs without a constant in go-cid
+const (
+	codeProtobuf = 0x50
+	codeJSON     = 0x0200
+)
+
+// UseCodec sets the codec used to encode and decode values with Set, Find
+// and the other methods taking values. By default values are CBOR, encoded
+// with the Atlas of the store if it has one.
+func UseCodec(c Codec) Option {
+	return func(conf *config) {
+		conf.codec = c
+	}
+}
+
+// codec returns the codec values are encoded with.
+func (n *Node) codec() Codec {
+	if c := n.conf().codec; c != nil {
+		return c
+	}
+	return n.store.valueCodec()
+}
+
+// encodeKV encodes v with the codec of the HAMT into a pair for k.
+func (n *Node) encodeKV(ctx context.Context, k string, v interface{}) (*pb.KV, error) {
+	c := n.codec()
+	data, err := c.Encode(v)
+	if err != nil {
+		return nil, err
+	}
+	return n.newKV(ctx, k, data, c.Code())
+}
+
+// decodeKV decodes the value of kv into out with the codec of the HAMT.
+func (n *Node) decodeKV(kv *pb.KV, out interface{}) error {
+	c := n.codec()
+	if codecOf(kv) != c.Code() {
+		return ErrCodecMismatch
+	}
+	return c.Decode(kv.Value, out)
+}
+
+// codecOf returns the multicodec of the value of kv.
+func codecOf(kv *pb.KV) uint64 {
+	if kv.Codec == 0 {
+		return cid.DagCBOR
+	}
+	return kv.Codec
+}
+
+// newKV returns a pair holding data encoded with the codec code. dag-cbor
+// isn't recorded, so that maps with CBOR values keep the encoding they had
+// before codecs were.
+func newKV(k string, data []byte, code uint64) *pb.KV {
+	kv := &pb.KV{Key: k, Value: data}
+	if code != cid.DagCBOR {
+		kv.Codec = code
+	}
+	return kv
+}
+
+type cborCodec struct {
+	atlas *atlas.Atlas
+}
+
+// CBORCodec returns a codec encoding values as canonical CBOR with atl, or
+// with the types registered through cbor.RegisterCborType if atl is nil.
+func CBORCodec(atl *atlas.Atlas) Codec {
+	return cborCodec{atlas: atl}
+}
+
+func (c cborCodec) Code() uint64 {
+	return cid.DagCBOR
+}
+
+func (c cborCodec) Encode(v interface{}) ([]byte, error) {
+	if c.atlas == nil {
+		nd, err := WrapObject(v)
+		if err != nil {
+			return nil, err
+		}
+		return nd.RawData(), nil
+	}
+	return refmtcbor.MarshalAtlased(v, c.canonicalAtlas())
+}
+
+func (c cborCodec) Decode(data []byte, out interface{}) error {
+	if c.atlas == nil {
+		return cbor.DecodeInto(data, out)
+	}
+	return refmtcbor.UnmarshalAtlased(refmtcbor.DecodeOptions{}, data, out, c.canonicalAtlas())
+}
+
+// canonicalAtlas returns the atlas set to sort map keys the way canonical
+// CBOR requires, so that equal values always encode the same.
+func (c cborCodec) canonicalAtlas() atlas.Atlas {
+	return c.atlas.WithMapMorphism(atlas.MapMorphism{KeySortMode: atlas.KeySortMode_RFC7049})
+}
+
+type jsonCodec struct{}
+
+// JSONCodec encodes values with encoding/json.
+var JSONCodec Codec = jsonCodec{}
+
+func (jsonCodec) Code() uint64 {
+	return codeJSON
+}
+
+func (jsonCodec) Encode(v interface{}) ([]byte, error) {
+	return json.Marshal(v)
+}
+
+func (jsonCodec) Decode(data []byte, out interface{}) error {
+	return json.Unmarshal(data, out)
+}
+
+type protoCodec struct{}
+
+// ProtoCodec encodes values that are proto.Messages. The type of the message
+// is stored along with it, as in a google.protobuf.Any, and decoding into a
+// message of another type fails. Values can only be decoded with FindInto.
+var ProtoCodec Codec = protoCodec{}
+
+func (protoCodec) Code() uint64 {
+	return codeProtobuf
+}
+
+func (protoCodec) Encode(v interface{}) ([]byte, error) {
+	msg, ok := v.(proto.Message)
+	if !ok {
+		return nil, fmt.Errorf("could not convert %T into proto.Message", v)
+	}
+	any, err := goipldpb.MarshalAny(msg)
+	if err != nil {
+		return nil, err
+	}
+	return any.Marshal()
+}
+
+func (protoCodec) Decode(data []byte, out interface{}) error {
+	msg, ok := out.(proto.Message)
+	if !ok {
+		return fmt.Errorf("could not convert %T into proto.Message", out)
+	}
+	any := new(ptypes.Any)
+	if err := any.Unmarshal(data); err != nil {
+		return err
+	}
+	return goipldpb.UnmarshalAny(any, msg)
+}
+
+type rawCodec struct{}
+
+// RawCodec stores values that are []byte as they are. It is the codec of
+// SetRaw and FindRaw.
+var RawCodec Codec = rawCodec{}
+
+func (rawCodec) Code() uint64 {
+	return cid.Raw
+}
+
+func (rawCodec) Encode(v interface{}) ([]byte, error) {
+	b, ok := v.([]byte)
+	if !ok {
+		return nil, fmt.Errorf("could not convert %T into []byte", v)
+	}
+	return b, nil
+}
+
+func (rawCodec) Decode(data []byte, out interface{}) error {
+	switch out := out.(type) {
+	case *[]byte:
+		*out = data
+	case *interface{}:
+		*out = data
+	default:
+		return fmt.Errorf("could not decode raw value into %T", out)
+	}
+	return nil
+}
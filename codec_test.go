@@ -0,0 +1,155 @@
+package hamt
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"reflect"
+	"testing"
+
+	"github.com/quorumcontrol/go-hamt-ipld/pb"
+)
+
+type jsonPerson struct {
+	Name string `json:"name"`
+	Age  int    `json:"age"`
+}
+
+func TestCodecs(t *testing.T) {
+	ctx := context.Background()
+
+	cases := map[string]struct {
+		codec Codec
+		value interface{}
+		out   func() interface{}
+	}{
+		"cbor":  {CBORCodec(nil), map[string]interface{}{"a": "b"}, func() interface{} { return new(map[string]interface{}) }},
+		"json":  {JSONCodec, jsonPerson{Name: "alice", Age: 30}, func() interface{} { return new(jsonPerson) }},
+		"proto": {ProtoCodec, &pb.KV{Key: "inner", Value: []byte("value")}, func() interface{} { return new(pb.KV) }},
+		"raw":   {RawCodec, []byte("raw bytes"), func() interface{} { return new([]byte) }},
+	}
+
+	for name, tc := range cases {
+		cs := NewCborStore()
+		n := NewNode(cs, UseCodec(tc.codec), UseTreeBitWidth(4))
+		for i := 0; i < 100; i++ {
+			if err := n.Set(ctx, fmt.Sprintf("key%d", i), tc.value); err != nil {
+				t.Fatalf("%s: %s", name, err)
+			}
+		}
+		if err := n.Flush(ctx); err != nil {
+			t.Fatal(err)
+		}
+		c, err := cs.Put(ctx, n)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		loaded, err := LoadNode(ctx, cs, c, UseCodec(tc.codec), UseTreeBitWidth(4))
+		if err != nil {
+			t.Fatal(err)
+		}
+		for i := 0; i < 100; i++ {
+			out := tc.out()
+			if err := loaded.FindInto(ctx, fmt.Sprintf("key%d", i), out); err != nil {
+				t.Fatalf("%s: %s", name, err)
+			}
+			got := reflect.ValueOf(out).Elem().Interface()
+			want := tc.value
+			if msg, ok := want.(*pb.KV); ok {
+				want = *msg
+			}
+			if !reflect.DeepEqual(got, want) {
+				t.Fatalf("%s: expected %v, got %v", name, want, got)
+			}
+		}
+
+		kv, err := loaded.GetKV(ctx, "key0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if codecOf(kv) != tc.codec.Code() {
+			t.Fatalf("%s: expected the codec to be recorded with the value", name)
+		}
+
+		// reading with the wrong codec must fail rather than decode garbage
+		for other, otc := range cases {
+			if other == name {
+				continue
+			}
+			wrong, err := LoadNode(ctx, cs, c, UseCodec(otc.codec), UseTreeBitWidth(4))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := wrong.FindInto(ctx, "key0", otc.out()); err != ErrCodecMismatch {
+				t.Fatalf("%s read as %s: expected ErrCodecMismatch, got %v", name, other, err)
+			}
+		}
+	}
+}
+
+func TestCodecDefaultsToCBOR(t *testing.T) {
+	ctx := context.Background()
+	cs := NewCborStore()
+
+	a := NewNode(cs)
+	b := NewNode(cs, UseCodec(CBORCodec(nil)))
+	for _, n := range []*Node{a, b} {
+		if err := n.Set(ctx, "key", "value"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if !nodesEqual(t, cs, a, b) {
+		t.Fatal("the CBOR codec should give the same tree as the default")
+	}
+
+	kv, err := a.GetKV(ctx, "key")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if kv.Codec != 0 {
+		t.Fatal("CBOR values should not record their codec")
+	}
+}
+
+func TestRawCodecMatchesSetRaw(t *testing.T) {
+	ctx := context.Background()
+	n := NewNode(NewCborStore(), UseCodec(RawCodec))
+
+	if err := n.SetRaw(ctx, "a", []byte("a")); err != nil {
+		t.Fatal(err)
+	}
+	if err := n.Set(ctx, "b", []byte("b")); err != nil {
+		t.Fatal(err)
+	}
+	a, err := n.Find(ctx, "a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := n.FindRaw(ctx, "b")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(a.([]byte), []byte("a")) || !bytes.Equal(b, []byte("b")) {
+		t.Fatal("RawCodec values should be interchangeable with SetRaw and FindRaw")
+	}
+
+	if err := n.Set(ctx, "c", "not bytes"); err == nil {
+		t.Fatal("expected RawCodec to refuse values that aren't []byte")
+	}
+}
+
+func TestProtoCodecChecksType(t *testing.T) {
+	ctx := context.Background()
+	n := NewNode(NewCborStore(), UseCodec(ProtoCodec))
+
+	if err := n.Set(ctx, "kv", &pb.KV{Key: "k"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := n.FindInto(ctx, "kv", new(pb.Pointer)); err == nil {
+		t.Fatal("expected decoding into another message type to fail")
+	}
+	if err := n.Set(ctx, "str", "not a message"); err == nil {
+		t.Fatal("expected ProtoCodec to refuse values that aren't messages")
+	}
+}
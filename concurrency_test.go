@@ -0,0 +1,97 @@
+package hamt
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+
+	"github.com/quorumcontrol/go-hamt-ipld/pb"
+)
+
+func TestConcurrentAccess(t *testing.T) {
+	ctx := context.Background()
+	cs := NewCborStore()
+	n := NewNode(cs, UseTreeBitWidth(3))
+
+	workers := 8
+	perWorker := 300
+
+	var wg sync.WaitGroup
+	done := make(chan struct{})
+
+	// flush and walk the tree while it is being modified
+	var bgwg sync.WaitGroup
+	bgwg.Add(1)
+	go func() {
+		defer bgwg.Done()
+		for {
+			select {
+			case <-done:
+				return
+			default:
+			}
+			if err := n.Flush(ctx); err != nil {
+				t.Error(err)
+				return
+			}
+			if err := n.ForEach(ctx, func(*pb.KV) error { return nil }); err != nil {
+				t.Error(err)
+				return
+			}
+			if _, err := cs.Put(ctx, n); err != nil {
+				t.Error(err)
+				return
+			}
+		}
+	}()
+
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; i < perWorker; i++ {
+				k := fmt.Sprintf("w%d-key%d", w, i)
+				if err := n.Set(ctx, k, i); err != nil {
+					t.Error(err)
+					return
+				}
+			}
+			for i := 0; i < perWorker; i++ {
+				k := fmt.Sprintf("w%d-key%d", w, i)
+				out, err := n.Find(ctx, k)
+				if err != nil {
+					t.Errorf("should have found %s: %s", k, err)
+					return
+				}
+				if out.(int) != i {
+					t.Errorf("wrong value for %s: %v", k, out)
+					return
+				}
+			}
+			// delete the odd keys again
+			for i := 1; i < perWorker; i += 2 {
+				if err := n.Delete(ctx, fmt.Sprintf("w%d-key%d", w, i)); err != nil {
+					t.Error(err)
+					return
+				}
+			}
+		}(w)
+	}
+	wg.Wait()
+	close(done)
+	bgwg.Wait()
+
+	// the result must be identical to building the same map sequentially
+	expected := NewNode(cs, UseTreeBitWidth(3))
+	for w := 0; w < workers; w++ {
+		for i := 0; i < perWorker; i += 2 {
+			if err := expected.Set(ctx, fmt.Sprintf("w%d-key%d", w, i), i); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	if !nodesEqual(t, cs, n, expected) {
+		t.Fatal("concurrently built HAMT differs from sequentially built one")
+	}
+}
@@ -0,0 +1,136 @@
+package hamt
+
+import (
+	"bytes"
+	"context"
+
+	"github.com/quorumcontrol/go-hamt-ipld/pb"
+)
+
+// The operations below decide what to do with a key while holding the lock
+// on the node its bucket is in, in the same traversal that writes the
+// result, so no other writer can change the key between the read and the
+// write. Functions passed to them must not call back into the HAMT.
+
+// SetIfAbsent sets k to v unless k is already set. It reports whether v was
+// stored.
+func (n *Node) SetIfAbsent(ctx context.Context, k string, v interface{}) (bool, error) {
+	c := n.codec()
+	data, err := c.Encode(v)
+	if err != nil {
+		return false, err
+	}
+	applied := false
+	err = n.update(ctx, &hashBits{b: n.hashKey(k)}, k, func(cur *pb.KV) (*pb.KV, error) {
+		if cur != nil {
+			return nil, errUnchanged
+		}
+		applied = true
+		return n.newKV(ctx, k, data, c.Code())
+	})
+	return applied && err == nil, err
+}
+
+// CompareAndSwap sets k to new if its value is old, as encoded with the
+// codec of the HAMT. It reports whether new was stored. A key that isn't set
+// doesn't match any old value.
+func (n *Node) CompareAndSwap(ctx context.Context, k string, old, new interface{}) (bool, error) {
+	c := n.codec()
+	want, err := c.Encode(old)
+	if err != nil {
+		return false, err
+	}
+	data, err := c.Encode(new)
+	if err != nil {
+		return false, err
+	}
+
+	applied := false
+	err = n.update(ctx, &hashBits{b: n.hashKey(k)}, k, func(cur *pb.KV) (*pb.KV, error) {
+		if cur == nil || codecOf(cur) != c.Code() {
+			return nil, errUnchanged
+		}
+		cur, err := n.resolveKV(ctx, cur)
+		if err != nil {
+			return nil, err
+		}
+		if !bytes.Equal(cur.Value, want) {
+			return nil, errUnchanged
+		}
+		applied = true
+		return n.newKV(ctx, k, data, c.Code())
+	})
+	return applied && err == nil, err
+}
+
+// Update replaces the value of k with the result of f, which is given the
+// encoded value of k and whether it is set. The value f returns is stored
+// with the codec the old one was, or the codec of the HAMT if k wasn't set,
+// unless f asks for k to be deleted. Deleting a key that isn't set does
+// nothing.
+func (n *Node) Update(ctx context.Context, k string, f func(old []byte, exists bool) (new []byte, delete bool)) error {
+	return n.update(ctx, &hashBits{b: n.hashKey(k)}, k, func(cur *pb.KV) (*pb.KV, error) {
+		var old []byte
+		code := n.codec().Code()
+		if cur != nil {
+			resolved, err := n.resolveKV(ctx, cur)
+			if err != nil {
+				return nil, err
+			}
+			old, code = resolved.Value, codecOf(cur)
+		}
+
+		data, del := f(old, cur != nil)
+		if del {
+			if cur == nil {
+				return nil, errUnchanged
+			}
+			return nil, nil
+		}
+		return n.newKV(ctx, k, data, code)
+	})
+}
+
+// Swap sets k to v and returns the pair it replaced, or nil if k wasn't set.
+func (n *Node) Swap(ctx context.Context, k string, v interface{}) (*pb.KV, error) {
+	kv, err := n.encodeKV(ctx, k, v)
+	if err != nil {
+		return nil, err
+	}
+	var prev *pb.KV
+	err = n.update(ctx, &hashBits{b: n.hashKey(k)}, k, func(cur *pb.KV) (*pb.KV, error) {
+		if cur != nil {
+			resolved, err := n.resolveKV(ctx, cur)
+			if err != nil {
+				return nil, err
+			}
+			prev = resolved
+		}
+		return kv, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return prev, nil
+}
+
+// LoadAndDelete deletes k and returns the pair it was set to, or
+// ErrNotFound if it isn't set.
+func (n *Node) LoadAndDelete(ctx context.Context, k string) (*pb.KV, error) {
+	var prev *pb.KV
+	err := n.update(ctx, &hashBits{b: n.hashKey(k)}, k, func(cur *pb.KV) (*pb.KV, error) {
+		if cur == nil {
+			return nil, ErrNotFound
+		}
+		resolved, err := n.resolveKV(ctx, cur)
+		if err != nil {
+			return nil, err
+		}
+		prev = resolved
+		return nil, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return prev, nil
+}
@@ -0,0 +1,290 @@
+package hamt
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"sync"
+	"testing"
+
+	"github.com/ipfs/go-merkledag"
+)
+
+func TestSetIfAbsent(t *testing.T) {
+	ctx := context.Background()
+	n := NewNode(NewCborStore(), UseTreeBitWidth(3))
+
+	for i := 0; i < 200; i++ {
+		ok, err := n.SetIfAbsent(ctx, fmt.Sprintf("key%d", i), i)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !ok {
+			t.Fatalf("key%d: expected the value to be stored", i)
+		}
+	}
+	for i := 0; i < 200; i++ {
+		ok, err := n.SetIfAbsent(ctx, fmt.Sprintf("key%d", i), -1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if ok {
+			t.Fatalf("key%d: expected the value to be kept", i)
+		}
+	}
+
+	for i := 0; i < 200; i++ {
+		var out int
+		if err := n.FindInto(ctx, fmt.Sprintf("key%d", i), &out); err != nil {
+			t.Fatal(err)
+		}
+		if out != i {
+			t.Fatalf("key%d: expected %d, got %d", i, i, out)
+		}
+	}
+	if checkCounts(t, n) != 200 {
+		t.Fatalf("expected 200 pairs, got %d", n.Len())
+	}
+}
+
+func TestCompareAndSwap(t *testing.T) {
+	ctx := context.Background()
+	cs := NewCborStore()
+	n := NewNode(cs, UseValueThreshold(16))
+
+	if ok, err := n.CompareAndSwap(ctx, "missing", "a", "b"); err != nil || ok {
+		t.Fatalf("expected no swap of a missing key, got %v, %v", ok, err)
+	}
+	if _, err := n.GetKV(ctx, "missing"); err != ErrNotFound {
+		t.Fatalf("expected the key to stay unset, got %v", err)
+	}
+
+	// the large value is stored outside of the node
+	large := string(bytes.Repeat([]byte("x"), 100))
+	for _, v := range []string{"small", large} {
+		if err := n.Set(ctx, "k", v); err != nil {
+			t.Fatal(err)
+		}
+		if ok, err := n.CompareAndSwap(ctx, "k", "other", "new"); err != nil || ok {
+			t.Fatalf("expected no swap of a different value, got %v, %v", ok, err)
+		}
+		if ok, err := n.CompareAndSwap(ctx, "k", v, "new"); err != nil || !ok {
+			t.Fatalf("expected a swap, got %v, %v", ok, err)
+		}
+		var out string
+		if err := n.FindInto(ctx, "k", &out); err != nil {
+			t.Fatal(err)
+		}
+		if out != "new" {
+			t.Fatalf("expected the new value, got %q", out)
+		}
+	}
+
+	// values stored with another codec never match
+	if err := n.SetRaw(ctx, "raw", []byte("a")); err != nil {
+		t.Fatal(err)
+	}
+	if ok, err := n.CompareAndSwap(ctx, "raw", []byte("a"), "b"); err != nil || ok {
+		t.Fatalf("expected no swap of a raw value, got %v, %v", ok, err)
+	}
+}
+
+func TestConditionalNoOrphanValues(t *testing.T) {
+	ctx := context.Background()
+	cs := NewCborStore()
+	n := NewNode(cs, UseValueThreshold(16))
+
+	if err := n.Set(ctx, "k", "small"); err != nil {
+		t.Fatal(err)
+	}
+	large := string(bytes.Repeat([]byte("y"), 100))
+	if ok, err := n.SetIfAbsent(ctx, "k", large); err != nil || ok {
+		t.Fatalf("expected the value to be kept, got %v, %v", ok, err)
+	}
+	if ok, err := n.CompareAndSwap(ctx, "k", "other", large); err != nil || ok {
+		t.Fatalf("expected no swap of a different value, got %v, %v", ok, err)
+	}
+
+	// the large value was never written, as it wasn't stored
+	data, err := n.codec().Encode(large)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := cs.Nodes.Get(ctx, merkledag.NewRawNode(data).Cid()); err == nil {
+		t.Fatal("expected no block for a value that wasn't stored")
+	}
+
+	if ok, err := n.CompareAndSwap(ctx, "k", "small", large); err != nil || !ok {
+		t.Fatalf("expected a swap, got %v, %v", ok, err)
+	}
+	if _, err := cs.Nodes.Get(ctx, merkledag.NewRawNode(data).Cid()); err != nil {
+		t.Fatalf("expected the stored value to be written, got %v", err)
+	}
+}
+
+func TestUpdate(t *testing.T) {
+	ctx := context.Background()
+	n := NewNode(NewCborStore(), UseTreeBitWidth(3))
+
+	for i := 0; i < 100; i++ {
+		k := fmt.Sprintf("key%d", i)
+		if err := n.Update(ctx, k, func(old []byte, exists bool) ([]byte, bool) {
+			if exists {
+				t.Errorf("%s: expected the key to be unset", k)
+			}
+			return []byte(k), false
+		}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if checkCounts(t, n) != 100 {
+		t.Fatalf("expected 100 pairs, got %d", n.Len())
+	}
+
+	// new values keep the codec of the old ones
+	if err := n.SetRaw(ctx, "key0", []byte("raw")); err != nil {
+		t.Fatal(err)
+	}
+	if err := n.Update(ctx, "key0", func(old []byte, exists bool) ([]byte, bool) {
+		return append(old, '!'), false
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if v, err := n.FindRaw(ctx, "key0"); err != nil || string(v) != "raw!" {
+		t.Fatalf("expected raw!, got %q, %v", v, err)
+	}
+
+	for i := 0; i < 100; i++ {
+		if err := n.Update(ctx, fmt.Sprintf("key%d", i), func(old []byte, exists bool) ([]byte, bool) {
+			return nil, i%2 == 0
+		}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// deleting a missing key does nothing
+	if err := n.Update(ctx, "missing", func([]byte, bool) ([]byte, bool) {
+		return nil, true
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if checkCounts(t, n) != 50 {
+		t.Fatalf("expected 50 pairs, got %d", n.Len())
+	}
+
+	// the tree collapses as it would with Delete
+	expected := NewNode(NewCborStore(), UseTreeBitWidth(3))
+	for i := 1; i < 100; i += 2 {
+		if err := expected.Update(ctx, fmt.Sprintf("key%d", i), func([]byte, bool) ([]byte, bool) {
+			return nil, false
+		}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := n.Flush(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if err := expected.Flush(ctx); err != nil {
+		t.Fatal(err)
+	}
+	c1, err := n.store.Put(ctx, n)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c2, err := expected.store.Put(ctx, expected)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !c1.Equals(c2) {
+		t.Fatal("expected the same tree as one built without the deleted keys")
+	}
+}
+
+func TestUpdateConcurrentCounter(t *testing.T) {
+	ctx := context.Background()
+	n := NewNode(NewCborStore(), UseTreeBitWidth(3), UseCodec(RawCodec))
+
+	workers := 8
+	perWorker := 200
+	counters := 20
+
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; i < perWorker; i++ {
+				k := fmt.Sprintf("counter%d", (w+i)%counters)
+				if err := n.Update(ctx, k, func(old []byte, exists bool) ([]byte, bool) {
+					var c uint64
+					if exists {
+						c = binary.BigEndian.Uint64(old)
+					}
+					out := make([]byte, 8)
+					binary.BigEndian.PutUint64(out, c+1)
+					return out, false
+				}); err != nil {
+					t.Error(err)
+					return
+				}
+			}
+		}(w)
+	}
+	wg.Wait()
+
+	total := uint64(0)
+	for i := 0; i < counters; i++ {
+		v, err := n.FindRaw(ctx, fmt.Sprintf("counter%d", i))
+		if err != nil {
+			t.Fatal(err)
+		}
+		total += binary.BigEndian.Uint64(v)
+	}
+	if total != uint64(workers*perWorker) {
+		t.Fatalf("expected %d increments, got %d", workers*perWorker, total)
+	}
+	checkCounts(t, n)
+}
+
+func TestSwapAndLoadAndDelete(t *testing.T) {
+	ctx := context.Background()
+	n := NewNode(NewCborStore(), UseTreeBitWidth(3), UseValueThreshold(16))
+
+	large := string(bytes.Repeat([]byte("x"), 100))
+	prev, err := n.Swap(ctx, "k", large)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if prev != nil {
+		t.Fatalf("expected no previous pair, got %v", prev)
+	}
+
+	prev, err = n.Swap(ctx, "k", "small")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var out string
+	if err := n.decodeKV(prev, &out); err != nil {
+		t.Fatal(err)
+	}
+	if out != large {
+		t.Fatal("expected the previous value to be read back from the store")
+	}
+
+	prev, err = n.LoadAndDelete(ctx, "k")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := n.decodeKV(prev, &out); err != nil {
+		t.Fatal(err)
+	}
+	if out != "small" {
+		t.Fatalf("expected small, got %q", out)
+	}
+	if _, err := n.LoadAndDelete(ctx, "k"); err != ErrNotFound {
+		t.Fatalf("expected ErrNotFound, got %v", err)
+	}
+	if n.Len() != 0 {
+		t.Fatalf("expected an empty HAMT, got %d pairs", n.Len())
+	}
+}
@@ -0,0 +1,220 @@
+package hamt
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"testing"
+
+	cid "github.com/ipfs/go-cid"
+)
+
+// checkCounts verifies the count of every node in the tree against the
+// pairs actually below it, and returns the count of n.
+func checkCounts(t *testing.T, n *Node) int {
+	ctx := context.Background()
+	total := 0
+	for _, p := range n.Pointers {
+		if p.isShard() {
+			chnd, err := p.loadChild(ctx, n)
+			if err != nil {
+				t.Fatal(err)
+			}
+			total += checkCounts(t, chnd)
+			continue
+		}
+		total += len(p.Kvs)
+	}
+	if total != n.Len() {
+		t.Fatalf("node records %d pairs but holds %d", n.Len(), total)
+	}
+	return total
+}
+
+func TestLen(t *testing.T) {
+	ctx := context.Background()
+	cs := NewCborStore()
+
+	n := NewNode(cs, UseTreeBitWidth(3))
+	if n.Len() != 0 {
+		t.Fatal("expected an empty HAMT")
+	}
+
+	r := rand.New(rand.NewSource(3))
+	set := make(map[string]bool)
+	for i := 0; i < 3000; i++ {
+		k := fmt.Sprintf("key%d", r.Intn(1000))
+		if r.Intn(3) == 0 {
+			err := n.Delete(ctx, k)
+			if set[k] != (err == nil) {
+				t.Fatalf("unexpected result deleting %s: %v", k, err)
+			}
+			delete(set, k)
+		} else {
+			if err := n.Set(ctx, k, i); err != nil {
+				t.Fatal(err)
+			}
+			set[k] = true
+		}
+		if n.Len() != len(set) {
+			t.Fatalf("expected %d pairs, Len returned %d", len(set), n.Len())
+		}
+	}
+	checkCounts(t, n)
+
+	if err := n.Flush(ctx); err != nil {
+		t.Fatal(err)
+	}
+	c, err := cs.Put(ctx, n)
+	if err != nil {
+		t.Fatal(err)
+	}
+	loaded, err := LoadNode(ctx, cs, c, UseTreeBitWidth(3))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if loaded.Len() != len(set) {
+		t.Fatalf("expected the count to be stored, got %d instead of %d", loaded.Len(), len(set))
+	}
+	checkCounts(t, loaded)
+}
+
+func TestLenOtherWrites(t *testing.T) {
+	ctx := context.Background()
+	cs := NewCborStore()
+
+	var ops []Op
+	for i := 0; i < 2000; i++ {
+		ops = append(ops, Op{Key: fmt.Sprintf("key%d", i), Value: i})
+	}
+	for i := 0; i < 2000; i += 3 {
+		ops = append(ops, Op{Key: fmt.Sprintf("key%d", i), Delete: true})
+	}
+	batched := NewNode(cs, UseTreeBitWidth(4))
+	if _, err := batched.ApplyBatch(ctx, ops); err != nil {
+		t.Fatal(err)
+	}
+	if batched.Len() != 2000-667 {
+		t.Fatalf("unexpected batch count %d", batched.Len())
+	}
+	checkCounts(t, batched)
+
+	built, err := Build(ctx, cs, &sliceSource{kvs: randomKVs(t, 3000, 1000)}, []Option{UseTreeBitWidth(2)}, BuildMemoryLimit(50))
+	if err != nil {
+		t.Fatal(err)
+	}
+	pairs, err := built.AllPairs(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if built.Len() != len(pairs) {
+		t.Fatalf("expected %d pairs in the built HAMT, Len returned %d", len(pairs), built.Len())
+	}
+	checkCounts(t, built)
+
+	v2, err := batched.With(ctx, "new", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	v3, err := v2.Without(ctx, "key1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if batched.Len() != 1333 || v2.Len() != 1334 || v3.Len() != 1333 {
+		t.Fatalf("unexpected counts across versions: %d, %d, %d", batched.Len(), v2.Len(), v3.Len())
+	}
+	checkCounts(t, v3)
+}
+
+// stripCounts stores a copy of the tree under c in the format nodes had
+// before they recorded their count, and returns its root.
+func stripCounts(t *testing.T, cs *CborIpldStore, c cid.Cid) cid.Cid {
+	ctx := context.Background()
+	nd := new(Node)
+	if err := cs.Get(ctx, c, nd); err != nil {
+		t.Fatal(err)
+	}
+	for _, p := range nd.Pointers {
+		if p.isShard() {
+			p.SetLink(stripCounts(t, cs, p.Link()))
+		}
+	}
+	nd.count = 0
+	out, err := cs.Put(ctx, nd)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return out
+}
+
+func TestLenLegacyNodes(t *testing.T) {
+	ctx := context.Background()
+	_, n, vals := buildFlushedHamt(t, 1000, UseTreeBitWidth(3))
+	c, err := n.store.Put(ctx, n)
+	if err != nil {
+		t.Fatal(err)
+	}
+	legacy := stripCounts(t, n.store, c)
+
+	var raw Node
+	if err := n.store.Get(ctx, legacy, &raw); err != nil {
+		t.Fatal(err)
+	}
+	if !raw.uncounted {
+		t.Fatalf("expected a root without a count, got %d", raw.count)
+	}
+
+	violations, err := Validate(ctx, n.store, legacy, UseTreeBitWidth(3))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(violations) != 0 {
+		t.Fatalf("expected no violations in a legacy tree, got %v", violations)
+	}
+
+	loaded, err := LoadNode(ctx, n.store, legacy, UseTreeBitWidth(3))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if loaded.Len() != 1000 {
+		t.Fatalf("expected 1000 pairs, Len returned %d", loaded.Len())
+	}
+
+	// changes made before the tree is counted are counted along with it
+	loaded, err = LoadNode(ctx, n.store, legacy, UseTreeBitWidth(3))
+	if err != nil {
+		t.Fatal(err)
+	}
+	deleted := 0
+	for k := range vals {
+		if deleted == 10 {
+			break
+		}
+		if err := loaded.Delete(ctx, k); err != nil {
+			t.Fatal(err)
+		}
+		deleted++
+	}
+	if err := loaded.Set(ctx, "new", 1); err != nil {
+		t.Fatal(err)
+	}
+	if c, err := loaded.Count(ctx); err != nil || c != 991 {
+		t.Fatalf("expected 991 pairs, got %d, %v", c, err)
+	}
+	checkCounts(t, loaded)
+
+	if err := loaded.Flush(ctx); err != nil {
+		t.Fatal(err)
+	}
+	c, err = n.store.Put(ctx, loaded)
+	if err != nil {
+		t.Fatal(err)
+	}
+	reloaded, err := LoadNode(ctx, n.store, c, UseTreeBitWidth(3))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reloaded.uncounted || reloaded.Len() != 991 {
+		t.Fatalf("expected the count to be recorded, got %d", reloaded.Len())
+	}
+}
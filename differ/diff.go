@@ -33,7 +33,7 @@ func getDiffFromNodes(ctx context.Context, cs *hamt.CborIpldStore, existingHamt
 	for i, pointer := range newHamt.Pointers {
 		if len(existingHamt.Pointers) > 0 && len(existingHamt.Pointers) > i {
 			existingPointer := existingHamt.Pointers[i]
-			if !pointer.Link().Defined() && pointer.Link().Equals(existingPointer.Link()) {
+			if pointer.Link().Defined() && pointer.Link().Equals(existingPointer.Link()) {
 				continue // the links are the same, just continue
 			}
 		}
@@ -53,7 +53,19 @@ func getDiffFromNodes(ctx context.Context, cs *hamt.CborIpldStore, existingHamt
 				return nil, xerrors.Errorf("error getting pairs: %w", err)
 			}
 		} else {
-			vals = pointer.Kvs
+			vals = make([]*pb.KV, len(pointer.Kvs))
+			for i, kv := range pointer.Kvs {
+				vals[i] = kv
+				if len(kv.ValueLink) == 0 {
+					continue
+				}
+				// compare values stored outside of their node like GetKV returns them
+				resolved, err := newHamt.GetKV(ctx, kv.Key)
+				if err != nil {
+					return nil, xerrors.Errorf("error reading value: %w", err)
+				}
+				vals[i] = resolved
+			}
 		}
 
 		for _, kv := range vals {
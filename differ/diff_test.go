@@ -6,6 +6,7 @@ import (
 	"encoding/hex"
 	"testing"
 
+	cbor "github.com/ipfs/go-ipld-cbor"
 	"github.com/quorumcontrol/go-hamt-ipld"
 	"github.com/stretchr/testify/require"
 )
@@ -53,6 +54,56 @@ func TestDiff(t *testing.T) {
 	require.Len(t, diff, 1001)
 }
 
+func TestDiffExternalValues(t *testing.T) {
+	ctx := context.Background()
+	cs := hamt.NewCborStore()
+	hamt1 := hamt.NewNode(cs, hamt.UseValueThreshold(16))
+	hamt2 := hamt.NewNode(cs, hamt.UseValueThreshold(16))
+	vals := make(map[string][]byte)
+	for i := 0; i < 200; i++ {
+		k := randString()
+		v := make([]byte, 100)
+		rand.Read(v)
+		vals[k] = v
+		require.Nil(t, hamt2.Set(ctx, k, v))
+	}
+	require.Nil(t, hamt1.Flush(ctx))
+	require.Nil(t, hamt2.Flush(ctx))
+
+	// the new pairs hold their values, even when they are stored outside
+	// of their node
+	diff, err := FindNew(ctx, cs, hamt1, hamt2)
+	require.Nil(t, err)
+	require.Len(t, diff, 200)
+	for _, kv := range diff {
+		require.Empty(t, kv.ValueLink)
+		var out []byte
+		require.Nil(t, cbor.DecodeInto(kv.Value, &out))
+		require.Equal(t, vals[kv.Key], out)
+	}
+}
+
+func TestDiffChangedBucket(t *testing.T) {
+	ctx := context.Background()
+	cs := hamt.NewCborStore()
+	hamt1 := hamt.NewNode(cs)
+	hamt2 := hamt.NewNode(cs)
+	var keys []string
+	for i := 0; i < 20; i++ {
+		k := randString()
+		keys = append(keys, k)
+		require.Nil(t, hamt1.Set(ctx, k, randValue()))
+		require.Nil(t, hamt2.Set(ctx, k, randValue()))
+	}
+	require.Nil(t, hamt1.Flush(ctx))
+	require.Nil(t, hamt2.Flush(ctx))
+
+	// the pairs are in buckets of the root, which have no link to compare
+	diff, err := FindNew(ctx, cs, hamt1, hamt2)
+	require.Nil(t, err)
+	require.Len(t, diff, 20)
+}
+
 func randString() string {
 	buf := make([]byte, 18)
 	rand.Read(buf)
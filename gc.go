@@ -0,0 +1,185 @@
+package hamt
+
+import (
+	"context"
+	"fmt"
+
+	blocks "github.com/ipfs/go-block-format"
+	cid "github.com/ipfs/go-cid"
+	datastore "github.com/ipfs/go-datastore"
+	"github.com/ipfs/go-datastore/namespace"
+	dsq "github.com/ipfs/go-datastore/query"
+	blockstore "github.com/ipfs/go-ipfs-blockstore"
+	dshelp "github.com/ipfs/go-ipfs-ds-help"
+)
+
+// defaultGCBatchSize is the number of deletions GC commits at once when no
+// GCBatchSize option is given.
+const defaultGCBatchSize = 1000
+
+// GCOption configures GC.
+type GCOption func(*gcConfig)
+
+type gcConfig struct {
+	dryRun           bool
+	batchSize        int
+	maxDeletes       int
+	followValueLinks bool
+}
+
+// GCDryRun makes GC report the blocks it would delete without deleting them.
+func GCDryRun() GCOption {
+	return func(c *gcConfig) {
+		c.dryRun = true
+	}
+}
+
+// GCBatchSize sets the number of deletions GC commits to the datastore at
+// once. Deletions already committed stay done if GC fails or is cancelled.
+// size must be at least 1.
+func GCBatchSize(size int) GCOption {
+	if size < 1 {
+		panic(fmt.Sprintf("invalid GC batch size %d", size))
+	}
+	return func(c *gcConfig) {
+		c.batchSize = size
+	}
+}
+
+// GCMaxDeletes stops GC deleting once it has deleted max blocks, so that a
+// large collection can be spread over several runs. The rest of the
+// datastore is still scanned to count the blocks left, in GCResult.Remaining.
+// Nothing is kept between runs: every run marks all the blocks reachable from
+// the roots again. max must be at least 1.
+func GCMaxDeletes(max int) GCOption {
+	if max < 1 {
+		panic(fmt.Sprintf("invalid GC max deletes %d", max))
+	}
+	return func(c *gcConfig) {
+		c.maxDeletes = max
+	}
+}
+
+// GCFollowValueLinks keeps the blocks linked from CBOR values stored in the
+// HAMTs, and everything reachable from them, as well.
+func GCFollowValueLinks() GCOption {
+	return func(c *gcConfig) {
+		c.followValueLinks = true
+	}
+}
+
+// GCResult is the outcome of GC.
+type GCResult struct {
+	// Marked is the number of blocks reachable from the roots.
+	Marked int
+	// Deleted holds the blocks that were deleted, or would have been on a
+	// dry run.
+	Deleted []cid.Cid
+	// Remaining is the number of unreachable blocks left in the datastore
+	// because of GCMaxDeletes.
+	Remaining int
+}
+
+// GC deletes every block from ds, a datastore as used by
+// FromDatastoreOffline, that isn't reachable from roots. The HAMTs the
+// roots point to are followed down to their values, including values stored
+// outside of their nodes; roots may also point to any other dag-pb, dag-cbor
+// or raw block. GC fails without deleting anything if a reachable block
+// can't be read.
+//
+// Nothing may write to ds while GC runs. DAG services already opened on ds
+// with FromDatastoreOffline cache which blocks they hold, and must be opened
+// again afterwards.
+func GC(ctx context.Context, ds datastore.Batching, roots []cid.Cid, opts ...GCOption) (*GCResult, error) {
+	conf := &gcConfig{batchSize: defaultGCBatchSize}
+	for _, o := range opts {
+		o(conf)
+	}
+
+	// identity hashed blocks are never written to ds, they hold their data
+	// in their CID
+	bs := blockstore.NewIdStore(blockstore.NewBlockstore(ds))
+	get := func(_ context.Context, c cid.Cid) (blocks.Block, error) {
+		return bs.Get(c)
+	}
+	live := cid.NewSet()
+	err := walkBlocks(ctx, get, roots, conf.followValueLinks, func(blk blocks.Block) error {
+		live.Add(blk.Cid())
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	bds := namespace.Wrap(ds, blockstore.BlockPrefix)
+	res := &GCResult{Marked: live.Len()}
+	err = sweepBlocks(ctx, bds, live, conf, res)
+	return res, err
+}
+
+// sweepBlocks deletes the blocks in bds, a block namespace, that aren't in
+// live, as it comes across them, a batch at a time. Once conf.maxDeletes
+// blocks are deleted the rest are only counted.
+func sweepBlocks(ctx context.Context, bds datastore.Batching, live *cid.Set, conf *gcConfig, res *GCResult) error {
+	q, err := bds.Query(dsq.Query{KeysOnly: true})
+	if err != nil {
+		return err
+	}
+	defer q.Close()
+
+	var batch []cid.Cid
+	flush := func() error {
+		if len(batch) == 0 {
+			return nil
+		}
+		if !conf.dryRun {
+			if err := deleteBlocks(bds, batch); err != nil {
+				return err
+			}
+		}
+		res.Deleted = append(res.Deleted, batch...)
+		batch = batch[:0]
+		return nil
+	}
+
+	for e := range q.Next() {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if e.Error != nil {
+			return e.Error
+		}
+		c, err := dshelp.DsKeyToCid(datastore.RawKey(e.Key))
+		if err != nil {
+			// not a block
+			continue
+		}
+		if live.Has(c) {
+			continue
+		}
+		if conf.maxDeletes > 0 && len(res.Deleted)+len(batch) >= conf.maxDeletes {
+			res.Remaining++
+			continue
+		}
+		batch = append(batch, c)
+		if len(batch) >= conf.batchSize {
+			if err := flush(); err != nil {
+				return err
+			}
+		}
+	}
+	return flush()
+}
+
+func deleteBlocks(bds datastore.Batching, cids []cid.Cid) error {
+	b, err := bds.Batch()
+	if err != nil {
+		return err
+	}
+	for _, c := range cids {
+		if err := b.Delete(dshelp.CidToDsKey(c)); err != nil {
+			return err
+		}
+	}
+	return b.Commit()
+}
@@ -0,0 +1,224 @@
+package hamt
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"testing"
+
+	cid "github.com/ipfs/go-cid"
+	datastore "github.com/ipfs/go-datastore"
+	dsync "github.com/ipfs/go-datastore/sync"
+	"github.com/ipfs/go-merkledag"
+	mh "github.com/multiformats/go-multihash"
+)
+
+func TestGC(t *testing.T) {
+	ctx := context.Background()
+	ds := dsync.MutexWrap(datastore.NewMapDatastore())
+	blockCount := func() int {
+		res, err := GC(ctx, ds, nil, GCDryRun())
+		if err != nil {
+			t.Fatal(err)
+		}
+		return len(res.Deleted)
+	}
+
+	dags, err := FromDatastoreOffline(ctx, ds)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cs := CSTFromDAG(dags)
+
+	linked := merkledag.NewRawNode([]byte("linked from a value"))
+	if err := dags.Add(ctx, linked); err != nil {
+		t.Fatal(err)
+	}
+
+	n := NewNode(cs, UseTreeBitWidth(3), UseValueThreshold(100), UseValueChunkSize(64))
+	for i := 0; i < 500; i++ {
+		if err := n.Set(ctx, fmt.Sprintf("key%d", i), i); err != nil {
+			t.Fatal(err)
+		}
+		if i%50 == 0 {
+			if err := n.Flush(ctx); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	big := make([]byte, 1000)
+	for i := range big {
+		big[i] = byte(i)
+	}
+	if err := n.SetRaw(ctx, "big", big); err != nil {
+		t.Fatal(err)
+	}
+	if err := n.Set(ctx, "link", map[string]interface{}{"c": linked.Cid()}); err != nil {
+		t.Fatal(err)
+	}
+	if err := n.Flush(ctx); err != nil {
+		t.Fatal(err)
+	}
+	root, err := cs.Put(ctx, n)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	before := blockCount()
+	dry, err := GC(ctx, ds, []cid.Cid{root}, GCDryRun())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(dry.Deleted) == 0 || dry.Marked+len(dry.Deleted) != before {
+		t.Fatalf("expected garbage among the %d blocks, got %+v", before, dry)
+	}
+	if blockCount() != before {
+		t.Fatal("dry run deleted blocks")
+	}
+
+	partial, err := GC(ctx, ds, []cid.Cid{root}, GCMaxDeletes(10), GCBatchSize(3), GCFollowValueLinks())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(partial.Deleted) != 10 || partial.Remaining != len(dry.Deleted)-11 {
+		t.Fatalf("expected 10 deletions leaving %d, got %d leaving %d", len(dry.Deleted)-11, len(partial.Deleted), partial.Remaining)
+	}
+
+	res, err := GC(ctx, ds, []cid.Cid{root}, GCFollowValueLinks())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(res.Deleted) != partial.Remaining || res.Remaining != 0 {
+		t.Fatalf("expected the remaining %d blocks to be deleted, got %+v", partial.Remaining, res)
+	}
+	if blockCount() != dry.Marked+1 {
+		t.Fatalf("expected %d blocks to be left, got %d", dry.Marked+1, blockCount())
+	}
+
+	// without following value links, the block linked from "link" goes
+	res, err = GC(ctx, ds, []cid.Cid{root})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(res.Deleted) != 1 || !res.Deleted[0].Equals(linked.Cid()) {
+		t.Fatalf("expected only the linked block to be deleted, got %v", res.Deleted)
+	}
+
+	dags, err = FromDatastoreOffline(ctx, ds)
+	if err != nil {
+		t.Fatal(err)
+	}
+	loaded, err := LoadNode(ctx, CSTFromDAG(dags), root, UseTreeBitWidth(3))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 500; i++ {
+		var out int
+		if err := loaded.FindInto(ctx, fmt.Sprintf("key%d", i), &out); err != nil || out != i {
+			t.Fatalf("expected %d for key%d, got %d (%v)", i, i, out, err)
+		}
+	}
+	r, err := loaded.ValueReader(ctx, "big")
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := ioutil.ReadAll(r)
+	if err != nil || string(data) != string(big) {
+		t.Fatalf("large value did not survive GC (%v)", err)
+	}
+}
+
+func TestGCMissingBlock(t *testing.T) {
+	ctx := context.Background()
+	ds := dsync.MutexWrap(datastore.NewMapDatastore())
+
+	missing := merkledag.NewRawNode([]byte("never stored"))
+	if _, err := GC(ctx, ds, []cid.Cid{missing.Cid()}); err == nil {
+		t.Fatal("expected an error for a missing root")
+	}
+}
+
+func TestGCIdentityLinks(t *testing.T) {
+	ctx := context.Background()
+	ds := dsync.MutexWrap(datastore.NewMapDatastore())
+	dags, err := FromDatastoreOffline(ctx, ds)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cs := CSTFromDAG(dags)
+
+	inline, err := cid.Prefix{Version: 1, Codec: cid.Raw, MhType: mh.ID, MhLength: -1}.Sum([]byte("inline"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	n := NewNode(cs)
+	if err := n.Set(ctx, "link", map[string]interface{}{"c": inline}); err != nil {
+		t.Fatal(err)
+	}
+	if err := n.Flush(ctx); err != nil {
+		t.Fatal(err)
+	}
+	root, err := cs.Put(ctx, n)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	res, err := GC(ctx, ds, []cid.Cid{root}, GCFollowValueLinks())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Marked != 2 || len(res.Deleted) != 0 {
+		t.Fatalf("expected the root and the identity block to be marked, got %+v", res)
+	}
+}
+
+func TestGCExternalValueLinks(t *testing.T) {
+	ctx := context.Background()
+	ds := dsync.MutexWrap(datastore.NewMapDatastore())
+	dags, err := FromDatastoreOffline(ctx, ds)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cs := CSTFromDAG(dags)
+
+	linked := merkledag.NewRawNode([]byte("linked from a large value"))
+	if err := dags.Add(ctx, linked); err != nil {
+		t.Fatal(err)
+	}
+	// the value is stored outside of the node, split over several chunks
+	n := NewNode(cs, UseValueThreshold(100), UseValueChunkSize(64))
+	v := map[string]interface{}{"c": linked.Cid(), "pad": make([]byte, 300)}
+	if err := n.Set(ctx, "link", v); err != nil {
+		t.Fatal(err)
+	}
+	if err := n.Flush(ctx); err != nil {
+		t.Fatal(err)
+	}
+	root, err := cs.Put(ctx, n)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	res, err := GC(ctx, ds, []cid.Cid{root}, GCFollowValueLinks())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(res.Deleted) != 0 {
+		t.Fatalf("expected nothing to be deleted, got %v", res.Deleted)
+	}
+	dags, err = FromDatastoreOffline(ctx, ds)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := dags.Get(ctx, linked.Cid()); err != nil {
+		t.Fatalf("expected the linked block to survive GC, got %v", err)
+	}
+
+	res, err = GC(ctx, ds, []cid.Cid{root})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(res.Deleted) != 1 || !res.Deleted[0].Equals(linked.Cid()) {
+		t.Fatalf("expected only the linked block to be deleted, got %v", res.Deleted)
+	}
+}
@@ -17,7 +17,7 @@ const googleApis = "type.googleapis.com/"
 var ErrUnimplemented = fmt.Errorf("unimplemented")
 
 func WrapObject(msg proto.Message) (format.Node, error) {
-	any, err := marshalAny(msg)
+	any, err := MarshalAny(msg)
 	if err != nil {
 		return nil, err
 	}
@@ -39,7 +39,7 @@ func DecodeInto(bits []byte, out proto.Message) error {
 	if err := any.Unmarshal(protonode.Data()); err != nil {
 		return err
 	}
-	return unmarshalAny(any, out)
+	return UnmarshalAny(any, out)
 }
 
 // UnmarshalAny parses the protocol buffer representation in a google.protobuf.Any
@@ -47,12 +47,12 @@ func DecodeInto(bits []byte, out proto.Message) error {
 // contents of Any message does not match type of pb message.
 //
 // pb can be a proto.Message, or a *DynamicAny.
-func unmarshalAny(any *ptypes.Any, pb proto.Message) error {
+func UnmarshalAny(any *ptypes.Any, pb proto.Message) error {
 	unmarshaler, ok := pb.(proto.Unmarshaler)
 	if !ok {
 		return fmt.Errorf("message must support unmarshal")
 	}
-	aname, err := anyMessageName(any)
+	aname, err := AnyMessageName(any)
 	if err != nil {
 		return err
 	}
@@ -65,7 +65,7 @@ func unmarshalAny(any *ptypes.Any, pb proto.Message) error {
 }
 
 // MarshalAny takes the protocol buffer and encodes it into google.protobuf.Any.
-func marshalAny(pb proto.Message) (*ptypes.Any, error) {
+func MarshalAny(pb proto.Message) (*ptypes.Any, error) {
 	marshaler, ok := pb.(proto.Marshaler)
 	if !ok {
 		return nil, fmt.Errorf("proto message must support Marshal")
@@ -82,7 +82,7 @@ func marshalAny(pb proto.Message) (*ptypes.Any, error) {
 // Note that regular type assertions should be done using the Is
 // function. AnyMessageName is provided for less common use cases like filtering a
 // sequence of Any messages based on a set of allowed message type names.
-func anyMessageName(any *ptypes.Any) (string, error) {
+func AnyMessageName(any *ptypes.Any) (string, error) {
 	if any == nil {
 		return "", fmt.Errorf("message is nil")
 	}
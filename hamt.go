@@ -4,16 +4,21 @@ import (
 	"context"
 	"fmt"
 	"math/big"
+	"sort"
 	"sync"
 
-	cbor "github.com/ipfs/go-ipld-cbor"
 	"github.com/quorumcontrol/go-hamt-ipld/pb"
 
 	cid "github.com/ipfs/go-cid"
-	murmur3 "github.com/spaolacci/murmur3"
 )
 
-const arrayWidth = 3
+// defaultBucketSize is the number of key/value pairs a pointer may hold
+// before it is split into a subshard when no UseBucketSize option is given.
+const defaultBucketSize = 3
+
+// defaultBitWidth is the number of hash bits consumed per level when no
+// UseTreeBitWidth option is given, giving each node a fanout of 256.
+const defaultBitWidth = 8
 
 type pointerSlice []*Pointer
 
@@ -34,26 +39,136 @@ func fromProtobufs(pbs []*pb.Pointer) pointerSlice {
 	return ret
 }
 
+// Node is a node of the HAMT, and the handle to a whole HAMT when it is the
+// root. All of its methods are safe for concurrent use.
 type Node struct {
 	Bitfield *big.Int     `refmt:"bf"`
 	Pointers pointerSlice `refmt:"p"`
 
+	// count is the number of pairs in the subtree rooted at the node
+	count uint64
+	// uncounted is set on nodes stored before nodes recorded their count.
+	// Changes are still added to their count, so that they can be passed up
+	// the tree, but it only holds the number of pairs once countPairs has
+	// been run.
+	uncounted bool
+	// bitWidth is the bit width recorded in a root. It is 0 on every other
+	// node, and on roots stored before roots recorded their width.
+	bitWidth int
+	// depth is the number of levels above the node, used to limit the
+	// depth of trees loaded with strict decoding
+	depth int
+
 	// for fetching and storing children
-	store  *CborIpldStore
-	pbNode *pb.Node
+	store *CborIpldStore
+
+	// config is shared by a root and all of its children
+	config *config
+
+	// owner is the root that may modify this node in place. Nodes with a
+	// different owner are shared with other versions of the HAMT and are
+	// copied before being modified.
+	owner *owner
+
+	// mu guards Bitfield, Pointers, count and the pointers themselves. Writers lock
+	// a child before unlocking its parent, so locks are always taken from
+	// the root down.
+	mu sync.RWMutex
+	// opMu is only used on the root: it is held for reading by mutations and
+	// for writing by Flush, so a flush never sees a half applied change.
+	opMu sync.RWMutex
+}
+
+// Option configures a HAMT when it is created with NewNode or loaded with
+// LoadNode.
+type Option func(*config)
+
+type config struct {
+	bitWidth int
+	// bitWidthSet is set when the width was given with UseTreeBitWidth
+	// rather than left to the default or the width recorded in the root
+	bitWidthSet bool
+	bucketSize  int
+	hasher      Hasher
+	codec       Codec
+
+	valueThreshold int
+	valueChunkSize int
+	// limits is only set for strict decoding
+	limits *DecodeLimits
+}
+
+func newConfig(opts ...Option) *config {
+	c := &config{
+		bitWidth:   defaultBitWidth,
+		bucketSize: defaultBucketSize,
+		hasher:     Murmur3Hasher,
+
+		valueChunkSize: defaultValueChunkSize,
+	}
+	for _, o := range opts {
+		o(c)
+	}
+	return c
+}
+
+var defaultConf = newConfig()
+
+// conf returns the configuration of the node, falling back to the defaults
+// for nodes that were decoded directly rather than through NewNode or LoadNode.
+func (n *Node) conf() *config {
+	if n.config == nil {
+		return defaultConf
+	}
+	return n.config
+}
+
+// UseTreeBitWidth sets the number of hash bits consumed at each level of the
+// tree, so that every node has a fanout of 2^bitWidth. Smaller widths give
+// smaller nodes and cheaper copy-on-write at the cost of a deeper tree.
+// The root records the width, which is used when loading the HAMT without
+// this option, and loading it with a different one fails with
+// ErrBitWidthMismatch. bitWidth must be between 1 and 8.
+func UseTreeBitWidth(bitWidth int) Option {
+	if bitWidth < 1 || bitWidth > 8 {
+		panic(fmt.Sprintf("invalid HAMT bit width %d", bitWidth))
+	}
+	return func(c *config) {
+		c.bitWidth = bitWidth
+		c.bitWidthSet = true
+	}
+}
+
+// UseBucketSize sets the number of key/value pairs stored in a pointer
+// before it is split into a subshard. Deletions collapse subshards back into
+// buckets using the same limit. Larger buckets suit small values, giving a
+// shallower tree with fewer blocks. The same size must be used when loading
+// a HAMT as when it was built. bucketSize must be at least 1.
+func UseBucketSize(bucketSize int) Option {
+	if bucketSize < 1 {
+		panic(fmt.Sprintf("invalid HAMT bucket size %d", bucketSize))
+	}
+	return func(c *config) {
+		c.bucketSize = bucketSize
+	}
 }
 
 func (n *Node) Marshal() ([]byte, error) {
-	n.populatePbNode()
-	return n.pbNode.Marshal()
+	return n.pbNode().Marshal()
 }
 
-func (n *Node) populatePbNode() {
-	if n.pbNode == nil {
-		n.pbNode = new(pb.Node)
+func (n *Node) pbNode() *pb.Node {
+	n.mu.RLock()
+	defer n.mu.RUnlock()
+	nd := &pb.Node{
+		Bitfield: n.Bitfield.Bytes(),
+		Pointers: n.Pointers.toProtoBufs(),
+		BitWidth: uint32(n.bitWidth),
+	}
+	if !n.uncounted {
+		nd.Count = n.count
 	}
-	n.pbNode.Bitfield = n.Bitfield.Bytes()
-	n.pbNode.Pointers = n.Pointers.toProtoBufs()
+	return nd
 }
 
 func (n *Node) Unmarshal(bits []byte) error {
@@ -63,31 +178,46 @@ func (n *Node) Unmarshal(bits []byte) error {
 	}
 	n.Bitfield = new(big.Int).SetBytes(pbNode.Bitfield)
 	n.Pointers = fromProtobufs(pbNode.Pointers)
+	n.count = pbNode.Count
+	// a node holding pointers has pairs below it, so a count of zero means
+	// it wasn't recorded
+	n.uncounted = n.count == 0 && len(n.Pointers) > 0
+	n.bitWidth = int(pbNode.BitWidth)
 	return nil
 }
 
 // Reset implements the proto.Message interface
 func (n *Node) Reset() {
+	n.mu.Lock()
+	defer n.mu.Unlock()
 	n.Bitfield = big.NewInt(0)
 	n.Pointers = make(pointerSlice, 0)
-	n.populatePbNode()
+	n.count = 0
+	n.uncounted = false
+	n.bitWidth = 0
 }
 
 // String implements the proto.Message interface
 func (n *Node) String() string {
-	n.populatePbNode()
-	return n.pbNode.String()
+	return n.pbNode().String()
 }
 
 // ProtoMessage implements the proto.Message interface
 func (n *Node) ProtoMessage() {}
 
-func NewNode(cs *CborIpldStore) *Node {
+func NewNode(cs *CborIpldStore, opts ...Option) *Node {
+	nd := newNode(cs, newConfig(opts...))
+	nd.bitWidth = nd.config.bitWidth
+	nd.owner = newOwner()
+	return nd
+}
+
+func newNode(cs *CborIpldStore, conf *config) *Node {
 	return &Node{
-		pbNode:   new(pb.Node),
 		Bitfield: big.NewInt(0),
 		Pointers: make(pointerSlice, 0),
 		store:    cs,
+		config:   conf,
 	}
 }
 
@@ -101,69 +231,84 @@ func newPointer() *Pointer {
 	return &Pointer{Pointer: new(pb.Pointer)}
 }
 
-var hash = func(k string) []byte {
-	h := murmur3.New128()
-	h.Write([]byte(k))
-	return h.Sum(nil)
+// hashKey hashes k with the hasher the HAMT was configured with.
+func (n *Node) hashKey(k string) []byte {
+	return n.conf().hasher.Hash(k)
 }
 
 func (n *Node) Find(ctx context.Context, k string) (interface{}, error) {
-	var out interface{}
-	err := n.getValue(ctx, hash(k), 0, k, func(kv *pb.KV) error {
-		err := cbor.DecodeInto(kv.Value, &out)
-		if err != nil {
-			return err
-		}
-		return nil
-	})
+	kv, err := n.GetKV(ctx, k)
 	if err != nil {
 		return nil, err
 	}
+	var out interface{}
+	if err := n.decodeKV(kv, &out); err != nil {
+		return nil, err
+	}
 	return out, nil
 }
 
+// FindInto decodes the value of k into out, which must be a pointer, with
+// the codec of the HAMT. Unlike Find, this allows decoding straight into a
+// struct or, with ProtoCodec, a proto.Message.
+func (n *Node) FindInto(ctx context.Context, k string, out interface{}) error {
+	kv, err := n.GetKV(ctx, k)
+	if err != nil {
+		return err
+	}
+	return n.decodeKV(kv, out)
+}
+
+// GetKV returns the pair stored under k. A value stored outside of its node
+// is read back from the store, so the pair always holds the value itself.
 func (n *Node) GetKV(ctx context.Context, k string) (*pb.KV, error) {
 	var out *pb.KV
-	err := n.getValue(ctx, hash(k), 0, k, func(kv *pb.KV) error {
+	err := n.getValue(ctx, &hashBits{b: n.hashKey(k)}, k, func(kv *pb.KV) error {
 		out = kv
 		return nil
 	})
 	if err != nil {
 		return nil, err
 	}
-	return out, nil
+	return n.resolveKV(ctx, out)
 }
 
 func (n *Node) Delete(ctx context.Context, k string) error {
-	return n.modifyValue(ctx, hash(k), 0, k, nil)
+	return n.modify(ctx, &hashBits{b: n.hashKey(k)}, k, nil)
 }
 
 var ErrNotFound = fmt.Errorf("not found")
+
+// ErrMaxDepth is returned when a lookup runs out of hash bits before reaching
+// a bucket, which means the tree was built with a different hasher.
 var ErrMaxDepth = fmt.Errorf("attempted to traverse hamt beyond max depth")
 
-func (n *Node) getValue(ctx context.Context, hv []byte, depth int, k string, cb func(*pb.KV) error) error {
-	if depth >= len(hv) {
-		return ErrMaxDepth
-	}
+var errNotLoaded = fmt.Errorf("child not loaded")
 
-	idx := hv[depth]
-	if n.Bitfield.Bit(int(idx)) == 0 {
-		return ErrNotFound
+func (n *Node) getValue(ctx context.Context, hv *hashBits, k string, cb func(*pb.KV) error) error {
+	idx, err := hv.Next(n.conf().bitWidth)
+	if err != nil {
+		return err
 	}
 
-	cindex := byte(n.indexForBitPos(int(idx)))
-
-	c := n.getChild(cindex)
-	if c.isShard() {
-		chnd, err := c.loadChild(ctx, n.store)
-		if err != nil {
-			return err
-		}
+	// only take the write lock if a child has to be loaded into the cache
+	n.mu.RLock()
+	chnd, kvs, err := n.childAt(ctx, idx, false)
+	n.mu.RUnlock()
+	if err == errNotLoaded {
+		n.mu.Lock()
+		chnd, kvs, err = n.childAt(ctx, idx, true)
+		n.mu.Unlock()
+	}
+	if err != nil {
+		return err
+	}
 
-		return chnd.getValue(ctx, hv, depth+1, k, cb)
+	if chnd != nil {
+		return chnd.getValue(ctx, hv, k, cb)
 	}
 
-	for _, kv := range c.Kvs {
+	for _, kv := range kvs {
 		if kv.Key == k {
 			return cb(kv)
 		}
@@ -172,80 +317,142 @@ func (n *Node) getValue(ctx context.Context, hv []byte, depth int, k string, cb
 	return ErrNotFound
 }
 
-func (p *Pointer) loadChild(ctx context.Context, ns *CborIpldStore) (*Node, error) {
+// childAt returns either the child node or the bucket stored under bit idx.
+// It is called with n.mu held. If load is set the lock must be held for
+// writing, and a child that isn't cached yet is loaded from the store;
+// otherwise errNotLoaded is returned for it. Buckets are never modified in
+// place, so the returned one stays valid after n.mu is released.
+func (n *Node) childAt(ctx context.Context, idx int, load bool) (*Node, []*pb.KV, error) {
+	if n.Bitfield.Bit(idx) == 0 {
+		return nil, nil, ErrNotFound
+	}
+
+	c := n.getChild(byte(n.indexForBitPos(idx)))
+	if c == nil {
+		return nil, nil, ErrMalformedNode
+	}
+	if !c.isShard() {
+		return nil, c.Kvs, nil
+	}
+
+	if c.cache == nil && !load {
+		return nil, nil, errNotLoaded
+	}
+	chnd, err := c.loadChild(ctx, n)
+	if err != nil {
+		return nil, nil, err
+	}
+	return chnd, nil, nil
+}
+
+// loadChild returns the node the pointer links to, loading it with the
+// store and configuration of its parent if it isn't already cached. The
+// parent must be locked for writing.
+func (p *Pointer) loadChild(ctx context.Context, parent *Node) (*Node, error) {
 	if p.cache != nil {
 		return p.cache, nil
 	}
 
-	out, err := LoadNode(ctx, ns, p.Link())
+	out, err := loadNode(ctx, parent.store, p.Link(), parent.config, parent.depth+1)
 	if err != nil {
 		return nil, err
 	}
 
+	out.owner = parent.owner
 	p.cache = out
 	return out, nil
 }
 
-func LoadNode(ctx context.Context, cs *CborIpldStore, c cid.Cid) (*Node, error) {
-	var out Node
-	if err := cs.Get(ctx, c, &out); err != nil {
+// LoadNode loads the HAMT root stored under c. The options must match the
+// ones the HAMT was created with, except for UseTreeBitWidth, which can be
+// left out for roots that record their width.
+func LoadNode(ctx context.Context, cs *CborIpldStore, c cid.Cid, opts ...Option) (*Node, error) {
+	nd, err := loadNode(ctx, cs, c, newConfig(opts...), 0)
+	if err != nil {
 		return nil, err
 	}
-
-	out.store = cs
-	return &out, nil
+	nd.owner = newOwner()
+	return nd, nil
 }
 
-func (n *Node) checkSize(ctx context.Context) (uint64, error) {
-	c, err := n.store.Put(ctx, n)
-	if err != nil {
-		return 0, err
-	}
-
-	blk, err := n.store.Nodes.Get(ctx, c)
-	if err != nil {
-		return 0, err
+// loadNode loads the node c, depth levels below the root, with strict
+// decoding if conf asks for it.
+func loadNode(ctx context.Context, cs *CborIpldStore, c cid.Cid, conf *config, depth int) (*Node, error) {
+	var out *Node
+	// nodes decoded directly have no config, and use the defaults
+	if conf == nil || conf.limits == nil {
+		out = new(Node)
+		if err := cs.Get(ctx, c, out); err != nil {
+			return nil, err
+		}
+	} else {
+		blk, err := cs.getBlock(ctx, c)
+		if err != nil {
+			return nil, err
+		}
+		if out, err = decodeNode(c, blk.RawData(), conf, depth); err != nil {
+			return nil, err
+		}
 	}
 
-	totsize := uint64(len(blk.RawData()))
-	for _, ch := range n.Pointers {
-		if ch.isShard() {
-			chnd, err := ch.loadChild(ctx, n.store)
-			if err != nil {
-				return 0, err
-			}
-			chsize, err := chnd.checkSize(ctx)
-			if err != nil {
-				return 0, err
-			}
-			totsize += chsize
+	if depth == 0 && conf != nil && conf.limits == nil {
+		if err := conf.useRootWidth(out); err != nil {
+			return nil, err
 		}
 	}
 
-	return totsize, nil
+	out.store = cs
+	out.config = conf
+	out.depth = depth
+	return out, nil
+}
+
+// ErrBitWidthMismatch is returned when a HAMT is loaded with a bit width
+// other than the one recorded in its root.
+var ErrBitWidthMismatch = fmt.Errorf("bit width does not match the one of the HAMT")
+
+// useRootWidth makes conf use the bit width recorded in nd, a root. Roots
+// that don't record their width keep the width of conf.
+func (conf *config) useRootWidth(nd *Node) error {
+	if nd.bitWidth == 0 {
+		return nil
+	}
+	if nd.bitWidth > 8 {
+		return ErrMalformedNode
+	}
+	if conf.bitWidthSet && conf.bitWidth != nd.bitWidth {
+		return ErrBitWidthMismatch
+	}
+	conf.bitWidth = nd.bitWidth
+	return nil
 }
 
-func (n *Node) AllPairs(ctx context.Context) ([]*pb.KV, error) {
+// AllPairs returns every key/value pair in the HAMT. It holds the whole map
+// in memory, so ForEach or Iterator should be preferred for large maps.
+func (n *Node) AllPairs(ctx context.Context, opts ...ReadOption) ([]*pb.KV, error) {
 	vals := make([]*pb.KV, 0)
-	for _, ch := range n.Pointers {
-		if ch.isShard() {
-			chnd, err := ch.loadChild(ctx, n.store)
-			if err != nil {
-				return nil, err
-			}
-			newVals, err := chnd.AllPairs(ctx)
-			if err != nil {
-				return nil, err
-			}
-			vals = append(vals, newVals...)
-		} else {
-			vals = append(vals, ch.Kvs...)
-		}
+	err := n.ForEach(ctx, func(kv *pb.KV) error {
+		vals = append(vals, kv)
+		return nil
+	}, opts...)
+	if err != nil {
+		return nil, err
 	}
 	return vals, nil
 }
 
+// Flush writes every modified child to the store, so that the node itself
+// can be stored. Concurrent writes wait for the flush to finish.
 func (n *Node) Flush(ctx context.Context) error {
+	n.opMu.Lock()
+	defer n.opMu.Unlock()
+	return n.flush(ctx)
+}
+
+func (n *Node) flush(ctx context.Context) error {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
 	var wg sync.WaitGroup
 	errChan := make(chan error, len(n.Pointers))
 	defer close(errChan)
@@ -254,7 +461,7 @@ func (n *Node) Flush(ctx context.Context) error {
 			wg.Add(1)
 			go func(p *Pointer) {
 				defer wg.Done()
-				if err := p.cache.Flush(ctx); err != nil {
+				if err := p.cache.flush(ctx); err != nil {
 					errChan <- err
 					return
 				}
@@ -281,29 +488,58 @@ func (n *Node) Flush(ctx context.Context) error {
 }
 
 func (n *Node) Set(ctx context.Context, k string, v interface{}) error {
-	nd, err := WrapObject(v)
+	kv, err := n.encodeKV(ctx, k, v)
 	if err != nil {
 		return err
 	}
-	err = n.modifyValue(ctx, hash(k), 0, k, nd.RawData())
-	return err
+	return n.modify(ctx, &hashBits{b: n.hashKey(k)}, k, kv)
+}
+
+// cleanChildAt collapses the subshard stored under bit idx into n if it has
+// become small enough after a deletion. It is called with n.mu held for
+// writing. Other writers may have changed n since the deletion, so the child
+// is looked up again.
+func (n *Node) cleanChildAt(ctx context.Context, idx int) error {
+	if n.Bitfield.Bit(idx) == 0 {
+		return nil
+	}
+	cindex := byte(n.indexForBitPos(idx))
+	child := n.getChild(cindex)
+	if child == nil {
+		return ErrMalformedNode
+	}
+	if !child.isShard() {
+		return nil
+	}
+
+	chnd, err := child.loadChild(ctx, n)
+	if err != nil {
+		return err
+	}
+	chnd.mu.RLock()
+	defer chnd.mu.RUnlock()
+	return n.cleanChild(chnd, cindex, idx)
 }
 
-func (n *Node) cleanChild(chnd *Node, cindex byte) error {
+func (n *Node) cleanChild(chnd *Node, cindex byte, idx int) error {
 	l := len(chnd.Pointers)
 	switch {
 	case l == 0:
-		return fmt.Errorf("incorrectly formed HAMT")
+		// concurrent deletions emptied the child before it could be collapsed
+		return n.rmChild(cindex, idx)
 	case l == 1:
-		// TODO: only do this if its a value, cant do this for shards unless pairs requirements are met.
-
 		ps := chnd.Pointers[0]
 		if ps.isShard() {
 			return nil
 		}
 
+		// a collision bucket can only live at the bottom of the tree
+		if len(ps.Kvs) > n.conf().bucketSize {
+			return nil
+		}
+
 		return n.setChild(cindex, ps)
-	case l <= arrayWidth:
+	case l <= n.conf().bucketSize:
 		var chvals []*pb.KV
 		for _, p := range chnd.Pointers {
 			if p.isShard() {
@@ -311,84 +547,248 @@ func (n *Node) cleanChild(chnd *Node, cindex byte) error {
 			}
 
 			for _, sp := range p.Kvs {
-				if len(chvals) == arrayWidth {
+				if len(chvals) == n.conf().bucketSize {
 					return nil
 				}
 				chvals = append(chvals, sp)
 			}
 		}
+		// keep the collapsed bucket in the same order insertion would
+		sort.Slice(chvals, func(i, j int) bool {
+			return chvals[i].Key < chvals[j].Key
+		})
 		return n.setChild(cindex, &Pointer{Pointer: &pb.Pointer{Kvs: chvals}})
 	default:
 		return nil
 	}
 }
 
-func (n *Node) modifyValue(ctx context.Context, hv []byte, depth int, k string, v []byte) error {
-	if depth >= len(hv) {
-		return ErrMaxDepth
+// modify stores the pair v under k, or deletes k if v is nil, in the tree
+// rooted at n.
+func (n *Node) modify(ctx context.Context, hv *hashBits, k string, v *pb.KV) error {
+	return n.update(ctx, hv, k, setTo(v))
+}
+
+// change decides the pair to store under a key given the pair stored under
+// it now, or nil if it isn't set. It returns nil to delete the key, or
+// errUnchanged to leave the tree as it is. It is called with the node
+// holding the bucket of the key locked for writing.
+type change func(old *pb.KV) (*pb.KV, error)
+
+// errUnchanged is returned by a change that doesn't modify the tree. It
+// never reaches the caller of update.
+var errUnchanged = fmt.Errorf("unchanged")
+
+// setTo returns a change that stores v regardless of the current pair, or
+// deletes the key if v is nil.
+func setTo(v *pb.KV) change {
+	return func(*pb.KV) (*pb.KV, error) {
+		return v, nil
+	}
+}
+
+// update applies ch to the pair under k in the tree rooted at n, in a single
+// traversal of its hash path.
+func (n *Node) update(ctx context.Context, hv *hashBits, k string, ch change) error {
+	n.opMu.RLock()
+	defer n.opMu.RUnlock()
+
+	n.mu.Lock()
+	_, err := n.modifyValue(ctx, hv, k, ch)
+	if err == errUnchanged {
+		return nil
+	}
+	return err
+}
+
+// modifyValue is called with n.mu held for writing and releases it. The lock
+// on a child is taken before the lock on its parent is released, so writes
+// to other parts of the tree can proceed while this one descends. It returns
+// the change in the number of pairs, which is added to the count of every
+// node on the way back up.
+func (n *Node) modifyValue(ctx context.Context, hv *hashBits, k string, ch change) (int, error) {
+	before := n.count
+	chnd, idx, err := n.modifyLocal(ctx, hv, k, ch)
+	delta := int(int64(n.count) - int64(before))
+	n.mu.Unlock()
+	if err != nil || chnd == nil {
+		return delta, err
+	}
+
+	delta, err = chnd.modifyValue(ctx, hv, k, ch)
+	if err != nil {
+		return 0, err
+	}
+
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.addCount(delta)
+
+	// CHAMP optimization, ensure trees look correct after deletions
+	if delta < 0 {
+		return delta, n.cleanChildAt(ctx, idx)
+	}
+
+	return delta, nil
+}
+
+// addCount adds delta to the count of n, which must be locked for writing.
+func (n *Node) addCount(delta int) {
+	n.count = uint64(int64(n.count) + int64(delta))
+}
+
+// Len returns the number of pairs in the HAMT. It takes constant time, as
+// every node records the number of pairs below it. HAMTs stored before nodes
+// recorded their count are counted on first use instead, and Len returns -1
+// if that fails; Count reports the error.
+func (n *Node) Len() int {
+	c, err := n.Count(context.Background())
+	if err != nil {
+		return -1
+	}
+	return c
+}
+
+// Count returns the number of pairs in the HAMT like Len. If the nodes of
+// the HAMT don't record their count, every node is loaded to count them.
+func (n *Node) Count(ctx context.Context) (int, error) {
+	n.mu.RLock()
+	c, uncounted := n.count, n.uncounted
+	n.mu.RUnlock()
+	if !uncounted {
+		return int(c), nil
+	}
+
+	n.opMu.Lock()
+	defer n.opMu.Unlock()
+	c, err := n.countPairs(ctx)
+	return int(c), err
+}
+
+// countPairs records the number of pairs below n in every uncounted node of
+// its subtree, and returns it. Nodes that aren't cached are only loaded for
+// counting, their count is recorded again when they are next loaded.
+func (n *Node) countPairs(ctx context.Context) (uint64, error) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if !n.uncounted {
+		return n.count, nil
+	}
+
+	total := uint64(0)
+	for _, p := range n.Pointers {
+		if !p.isShard() {
+			total += uint64(len(p.Kvs))
+			continue
+		}
+		chnd, err := p.peekChild(ctx, n)
+		if err != nil {
+			return 0, err
+		}
+		c, err := chnd.countPairs(ctx)
+		if err != nil {
+			return 0, err
+		}
+		total += c
+	}
+	n.count = total
+	n.uncounted = false
+	return total, nil
+}
+
+// modifyLocal applies a modification to n itself. When the key belongs in a
+// subshard, that child is returned locked for writing instead, along with the
+// bit it is stored under.
+func (n *Node) modifyLocal(ctx context.Context, hv *hashBits, k string, ch change) (*Node, int, error) {
+	idx, err := hv.Next(n.conf().bitWidth)
+	if err != nil {
+		return nil, 0, err
 	}
-	idx := int(hv[depth])
 
 	if n.Bitfield.Bit(idx) != 1 {
-		err := n.insertChild(idx, k, v)
-		return err
+		return nil, 0, n.insertChild(idx, k, ch)
 	}
 
 	cindex := byte(n.indexForBitPos(idx))
 
 	child := n.getChild(cindex)
+	if child == nil {
+		return nil, 0, ErrMalformedNode
+	}
 	if child.isShard() {
-		chnd, err := child.loadChild(ctx, n.store)
+		chnd, err := child.loadChild(ctx, n)
 		if err != nil {
-			return err
+			return nil, 0, err
 		}
-
-		if err := chnd.modifyValue(ctx, hv, depth+1, k, v); err != nil {
-			return err
+		if chnd.owner != n.owner {
+			chnd = chnd.cloneFor(n.owner)
+			child.cache = chnd
 		}
+		chnd.mu.Lock()
+		return chnd, idx, nil
+	}
 
-		// CHAMP optimization, ensure trees look correct after deletions
-		if v == nil {
-			if err := n.cleanChild(chnd, cindex); err != nil {
-				return err
-			}
-		}
+	return nil, 0, n.modifyBucket(ctx, hv, child, cindex, idx, k, ch)
+}
 
-		return nil
+// modifyBucket applies a modification to the bucket in child. Buckets are
+// replaced rather than modified in place, so that readers holding on to an
+// old one are unaffected.
+func (n *Node) modifyBucket(ctx context.Context, hv *hashBits, child *Pointer, cindex byte, idx int, k string, ch change) error {
+	i := -1
+	var old *pb.KV
+	for j, p := range child.Kvs {
+		if p.Key == k {
+			i, old = j, p
+			break
+		}
+	}
+	v, err := ch(old)
+	if err != nil {
+		return err
 	}
 
 	if v == nil {
-		for i, p := range child.Kvs {
-			if p.Key == k {
-				if len(child.Kvs) == 1 {
-					return n.rmChild(cindex, idx)
-				}
-
-				copy(child.Kvs[i:], child.Kvs[i+1:])
-				child.Kvs = child.Kvs[:len(child.Kvs)-1]
-				return nil
-			}
+		if old == nil {
+			return ErrNotFound
 		}
-		return ErrNotFound
+		n.count--
+		if len(child.Kvs) == 1 {
+			return n.rmChild(cindex, idx)
+		}
+
+		kvs := make([]*pb.KV, 0, len(child.Kvs)-1)
+		kvs = append(kvs, child.Kvs[:i]...)
+		child.Kvs = append(kvs, child.Kvs[i+1:]...)
+		return nil
 	}
 
-	// check if key already exists
-	for _, p := range child.Kvs {
-		if p.Key == k {
-			p.Value = v
-			return nil
-		}
+	if old != nil {
+		kvs := make([]*pb.KV, len(child.Kvs))
+		copy(kvs, child.Kvs)
+		kvs[i] = v
+		child.Kvs = kvs
+		return nil
 	}
 
-	// If the array is full, create a subshard and insert everything into it
-	if len(child.Kvs) >= arrayWidth {
-		sub := NewNode(n.store)
-		if err := sub.modifyValue(ctx, hv, depth+1, k, v); err != nil {
+	// If the array is full, create a subshard and insert everything into it.
+	// Once the hash has no bits left for another level the keys in this
+	// bucket collide completely, so it is allowed to grow past the bucket
+	// size instead.
+	if len(child.Kvs) >= n.conf().bucketSize && hv.hasNext(n.conf().bitWidth) {
+		sub := newNode(n.store, n.config)
+		sub.owner = n.owner
+		sub.depth = n.depth + 1
+		hvcopy := &hashBits{b: hv.b, consumed: hv.consumed}
+		sub.mu.Lock()
+		if _, err := sub.modifyValue(ctx, hvcopy, k, setTo(v)); err != nil {
 			return err
 		}
 
 		for _, p := range child.Kvs {
-			if err := sub.modifyValue(ctx, hash(p.Key), depth+1, p.Key, p.Value); err != nil {
+			chhv := &hashBits{b: n.hashKey(p.Key), consumed: hv.consumed}
+			sub.mu.Lock()
+			if _, err := sub.modifyValue(ctx, chhv, p.Key, setTo(p)); err != nil {
 				return err
 			}
 		}
@@ -400,30 +800,39 @@ func (n *Node) modifyValue(ctx context.Context, hv []byte, depth int, k string,
 
 		p := new(pb.Pointer)
 		p.SetLink(c)
+		n.count++
 		return n.setChild(cindex, &Pointer{Pointer: p})
 	}
 
 	// otherwise insert the new element into the array in order
-	np := &pb.KV{Key: k, Value: v}
+	n.count++
+	kvs := make([]*pb.KV, 0, len(child.Kvs)+1)
 	for i := 0; i < len(child.Kvs); i++ {
 		if k < child.Kvs[i].Key {
-			child.Kvs = append(child.Kvs[:i], append([]*pb.KV{np}, child.Kvs[i:]...)...)
+			kvs = append(kvs, v)
+			child.Kvs = append(kvs, child.Kvs[i:]...)
 			return nil
 		}
+		kvs = append(kvs, child.Kvs[i])
 	}
-	child.Kvs = append(child.Kvs, np)
+	child.Kvs = append(kvs, v)
 	return nil
 }
 
-func (n *Node) insertChild(idx int, k string, v []byte) error {
+func (n *Node) insertChild(idx int, k string, ch change) error {
+	v, err := ch(nil)
+	if err != nil {
+		return err
+	}
 	if v == nil {
 		return ErrNotFound
 	}
 
 	i := n.indexForBitPos(idx)
 	n.Bitfield.SetBit(n.Bitfield, idx, 1)
+	n.count++
 
-	p := &Pointer{Pointer: &pb.Pointer{Kvs: []*pb.KV{{Key: k, Value: v}}}}
+	p := &Pointer{Pointer: &pb.Pointer{Kvs: []*pb.KV{v}}}
 	n.Pointers = append(n.Pointers[:i], append([]*Pointer{p}, n.Pointers[i:]...)...)
 	return nil
 }
@@ -449,29 +858,13 @@ func (n *Node) getChild(i byte) *Pointer {
 	return n.Pointers[i]
 }
 
+// Copy returns an independent copy of the HAMT. It is the same as Snapshot.
 func (n *Node) Copy() *Node {
-	nn := NewNode(n.store)
-	nn.Bitfield.Set(n.Bitfield)
-	nn.Pointers = make([]*Pointer, len(n.Pointers))
-
-	for i, p := range n.Pointers {
-		pp := &Pointer{Pointer: new(pb.Pointer)}
-		if p.cache != nil {
-			pp.cache = p.cache.Copy()
-		}
-		pp.SetLink(p.Link())
-		if p.Kvs != nil {
-			pp.Kvs = make([]*pb.KV, len(p.Kvs))
-			for j, kv := range p.Kvs {
-				pp.Kvs[j] = &pb.KV{Key: kv.Key, Value: kv.Value}
-			}
-		}
-		nn.Pointers[i] = pp
-	}
-
-	return nn
+	return n.Snapshot()
 }
 
+// isShard reports whether p points to a subshard. Subshards created by a
+// batch are only cached until the next flush, so they have no link yet.
 func (p *Pointer) isShard() bool {
-	return p.Link().Defined()
+	return p.cache != nil || p.Link().Defined()
 }
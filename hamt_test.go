@@ -33,27 +33,13 @@ func dotGraph(n *Node) {
 	fmt.Println("}")
 }
 
-var identityHash = func(k string) []byte {
-	res := make([]byte, 32)
-	copy(res, []byte(k))
-	return res
-}
-
-var shortIdentityHash = func(k string) []byte {
-	res := make([]byte, 16)
-	copy(res, []byte(k))
-	return res
-}
+var identityHash = IdentityHasher(32)
 
-var murmurHash = hash
+var shortIdentityHash = IdentityHasher(16)
 
 func TestCanonicalStructure(t *testing.T) {
-	hash = identityHash
-	defer func() {
-		hash = murmurHash
-	}()
-	addAndRemoveKeys(t, []string{"K"}, []string{"B"})
-	addAndRemoveKeys(t, []string{"K0", "K1", "KAA1", "KAA2", "KAA3"}, []string{"KAA4"})
+	addAndRemoveKeys(t, []string{"K"}, []string{"B"}, UseHasher(identityHash))
+	addAndRemoveKeys(t, []string{"K0", "K1", "KAA1", "KAA2", "KAA3"}, []string{"KAA4"}, UseHasher(identityHash))
 }
 
 func TestGetKV(t *testing.T) {
@@ -95,26 +81,24 @@ func TestAllPairs(t *testing.T) {
 }
 
 func TestOverflow(t *testing.T) {
-	hash = identityHash
-	defer func() {
-		hash = murmurHash
-	}()
+	ctx := context.Background()
 	keys := make([]string, 4)
 	for i := range keys {
 		keys[i] = strings.Repeat("A", 32) + fmt.Sprintf("%d", i)
 	}
 
 	cs := NewCborStore()
-	n := NewNode(cs)
+	n := NewNode(cs, UseHasher(identityHash))
 	for _, k := range keys[:3] {
 		if err := n.Set(context.Background(), k, "foobar"); err != nil {
 			t.Error(err)
 		}
 	}
 
-	// Try forcing the depth beyond 32
-	if err := n.Set(context.Background(), keys[3], "bad"); err != ErrMaxDepth {
-		t.Errorf("expected error %q, got %q", ErrMaxDepth, err)
+	// The hash runs out at depth 32, so the fourth key goes into a
+	// collision bucket instead of another subshard.
+	if err := n.Set(context.Background(), keys[3], "collides"); err != nil {
+		t.Error(err)
 	}
 
 	// Force _to_ max depth.
@@ -122,15 +106,140 @@ func TestOverflow(t *testing.T) {
 		t.Error(err)
 	}
 
+	for _, k := range append(keys, keys[3][1:]) {
+		if _, err := n.Find(ctx, k); err != nil {
+			t.Errorf("should have found %s: %s", k, err)
+		}
+	}
+
 	// Now, try fetching with a shorter hash function.
-	hash = shortIdentityHash
-	_, err := n.Find(context.Background(), keys[0])
+	if err := n.Flush(ctx); err != nil {
+		t.Fatal(err)
+	}
+	c, err := cs.Put(ctx, n)
+	if err != nil {
+		t.Fatal(err)
+	}
+	short, err := LoadNode(ctx, cs, c, UseHasher(shortIdentityHash))
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = short.Find(ctx, keys[0])
 	if err != ErrMaxDepth {
 		t.Errorf("expected error %q, got %q", ErrMaxDepth, err)
 	}
 }
 
-func addAndRemoveKeys(t *testing.T, keys []string, extraKeys []string) {
+func TestCanonicalStructureBucketSizes(t *testing.T) {
+	keys := make([]string, 200)
+	for i := range keys {
+		keys[i] = randString()
+	}
+	extraKeys := make([]string, 50)
+	for i := range extraKeys {
+		extraKeys[i] = randString()
+	}
+
+	for _, bucketSize := range []int{1, 2, 8, 16} {
+		addAndRemoveKeys(t, keys, extraKeys, UseBucketSize(bucketSize), UseTreeBitWidth(5))
+	}
+}
+
+func TestBucketSize(t *testing.T) {
+	ctx := context.Background()
+	cs := NewCborStore()
+
+	for _, bucketSize := range []int{1, 5, 16} {
+		n := NewNode(cs, UseBucketSize(bucketSize), UseTreeBitWidth(4))
+		var keys []string
+		for i := 0; i < 1000; i++ {
+			k := randString()
+			keys = append(keys, k)
+			if err := n.Set(ctx, k, randValue()); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		st, err := n.Stats(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if st.Entries != len(keys) {
+			t.Fatalf("expected %d kvs, got %d", len(keys), st.Entries)
+		}
+		for size := range st.BucketFill {
+			if size > bucketSize {
+				t.Fatalf("found bucket of size %d with a bucket size of %d", size, bucketSize)
+			}
+		}
+
+		for _, k := range keys {
+			if err := n.Delete(ctx, k); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if len(n.Pointers) != 0 {
+			t.Fatalf("expected empty root after deleting everything, got %d pointers", len(n.Pointers))
+		}
+	}
+}
+
+func TestFullCollisions(t *testing.T) {
+	ctx := context.Background()
+	constantHash := HasherFunc(func(k string) []byte {
+		return []byte{0xab, 0xcd}
+	})
+
+	var keys []string
+	for i := 0; i < 50; i++ {
+		keys = append(keys, fmt.Sprintf("key%d", i))
+	}
+
+	cs := NewCborStore()
+	n := NewNode(cs, UseHasher(constantHash))
+	for _, k := range keys {
+		if err := n.Set(ctx, k, k); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, k := range keys {
+		out, err := n.Find(ctx, k)
+		if err != nil {
+			t.Fatalf("should have found %s: %s", k, err)
+		}
+		if out.(string) != k {
+			t.Fatalf("expected %s, got %v", k, out)
+		}
+	}
+	if _, err := n.Find(ctx, "missing"); err != ErrNotFound {
+		t.Fatalf("expected ErrNotFound, got %v", err)
+	}
+
+	st, err := n.Stats(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if st.Nodes != 2 || st.BucketFill[len(keys)] != 1 {
+		t.Fatalf("expected a single collision bucket below the root, got %v", st)
+	}
+
+	for _, k := range keys {
+		if err := n.Delete(ctx, k); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := n.Find(ctx, k); err != ErrNotFound {
+			t.Fatalf("expected ErrNotFound after deleting %s, got %v", k, err)
+		}
+	}
+	if len(n.Pointers) != 0 {
+		t.Fatalf("expected empty root after deleting everything, got %d pointers", len(n.Pointers))
+	}
+
+	addAndRemoveKeys(t, keys[:10], keys[10:], UseHasher(constantHash))
+	addAndRemoveKeys(t, keys[:2], keys[2:], UseHasher(constantHash), UseTreeBitWidth(3))
+}
+
+func addAndRemoveKeys(t *testing.T, keys []string, extraKeys []string, opts ...Option) {
 	ctx := context.Background()
 	vals := make(map[string][]byte)
 	for i := 0; i < len(keys); i++ {
@@ -139,7 +248,7 @@ func addAndRemoveKeys(t *testing.T, keys []string, extraKeys []string) {
 	}
 
 	cs := NewCborStore()
-	begn := NewNode(cs)
+	begn := NewNode(cs, opts...)
 
 	for _, k := range keys {
 		fmt.Println("set ", k)
@@ -159,11 +268,10 @@ func addAndRemoveKeys(t *testing.T, keys []string, extraKeys []string) {
 		t.Fatal(err)
 	}
 
-	var n Node
-	if err := cs.Get(ctx, c, &n); err != nil {
+	n, err := LoadNode(ctx, cs, c, opts...)
+	if err != nil {
 		t.Fatal(err)
 	}
-	n.store = cs
 
 	for k, v := range vals {
 		out, err := n.Find(ctx, k)
@@ -193,12 +301,11 @@ func addAndRemoveKeys(t *testing.T, keys []string, extraKeys []string) {
 		t.Fatal(err)
 	}
 
-	var n2 Node
-	if err := cs.Get(ctx, c2, &n2); err != nil {
+	n2, err := LoadNode(ctx, cs, c2, opts...)
+	if err != nil {
 		t.Fatal(err)
 	}
-	n2.store = cs
-	if !nodesEqual(t, cs, &n, &n2) {
+	if !nodesEqual(t, cs, n, n2) {
 		t.Fatal("nodes should be equal")
 	}
 }
@@ -209,7 +316,7 @@ func dotGraphRec(n *Node, name *int) {
 		if p.isShard() {
 			*name++
 			fmt.Printf("\tn%d -> n%d;\n", cur, *name)
-			nd, err := p.loadChild(context.Background(), n.store)
+			nd, err := p.loadChild(context.Background(), n)
 			if err != nil {
 				panic(err)
 			}
@@ -225,38 +332,9 @@ func dotGraphRec(n *Node, name *int) {
 	}
 }
 
-type hamtStats struct {
-	totalNodes int
-	totalKvs   int
-	counts     map[int]int
-}
-
-func stats(n *Node) *hamtStats {
-	st := &hamtStats{counts: make(map[int]int)}
-	statsrec(n, st)
-	return st
-}
-
-func statsrec(n *Node, st *hamtStats) {
-	st.totalNodes++
-	for _, p := range n.Pointers {
-		if p.isShard() {
-			nd, err := p.loadChild(context.Background(), n.store)
-			if err != nil {
-				panic(err)
-			}
-
-			statsrec(nd, st)
-		} else {
-			st.totalKvs += len(p.Kvs)
-			st.counts[len(p.Kvs)]++
-		}
-	}
-}
-
 func TestHash(t *testing.T) {
-	h1 := hash("abcd")
-	h2 := hash("abce")
+	h1 := Murmur3Hasher.Hash("abcd")
+	h2 := Murmur3Hasher.Hash("abce")
 	if h1[0] == h2[0] && h1[1] == h2[1] && h1[3] == h2[3] {
 		t.Fatal("Hash should give different strings different hash prefixes")
 	}
@@ -278,16 +356,17 @@ func TestSetGet(t *testing.T) {
 		begn.Set(ctx, k, vals[k])
 	}
 
-	size, err := begn.checkSize(ctx)
+	st, err := begn.Stats(ctx)
 	if err != nil {
 		t.Fatal(err)
 	}
+	size := st.BlockSizes.Total
 	mapsize := 0
 	for k, v := range vals {
 		mapsize += (len(k) + len(v))
 	}
 	fmt.Printf("Total size is: %d, size of keys+vals: %d, overhead: %.2f\n", size, mapsize, float64(size)/float64(mapsize))
-	fmt.Println(stats(begn))
+	fmt.Printf("%+v\n", st)
 
 	fmt.Println("start flush")
 	bef := time.Now()
@@ -362,6 +441,107 @@ func TestSetGet(t *testing.T) {
 	}
 }
 
+func TestSetGetBitWidths(t *testing.T) {
+	ctx := context.Background()
+	vals := make(map[string][]byte)
+	for i := 0; i < 2000; i++ {
+		vals[randString()] = randValue()
+	}
+
+	for bitWidth := 3; bitWidth <= 8; bitWidth++ {
+		cs := NewCborStore()
+		begn := NewNode(cs, UseTreeBitWidth(bitWidth))
+		for k, v := range vals {
+			if err := begn.Set(ctx, k, v); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		if err := begn.Flush(ctx); err != nil {
+			t.Fatal(err)
+		}
+		if begn.Bitfield.BitLen() > 1<<uint(bitWidth) {
+			t.Fatalf("bitfield of width %d node is too large: %d", bitWidth, begn.Bitfield.BitLen())
+		}
+		c, err := cs.Put(ctx, begn)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		n, err := LoadNode(ctx, cs, c, UseTreeBitWidth(bitWidth))
+		if err != nil {
+			t.Fatal(err)
+		}
+		for k, v := range vals {
+			out, err := n.Find(ctx, k)
+			if err != nil {
+				t.Fatalf("should have found %s with bit width %d: %s", k, bitWidth, err)
+			}
+			if !bytes.Equal(out.([]byte), v) {
+				t.Fatal("got wrong value")
+			}
+		}
+
+		for k := range vals {
+			if err := n.Delete(ctx, k); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if len(n.Pointers) != 0 {
+			t.Fatalf("expected empty root after deleting everything, got %d pointers", len(n.Pointers))
+		}
+	}
+}
+
+func TestLoadRecordedBitWidth(t *testing.T) {
+	ctx := context.Background()
+	cs := NewCborStore()
+	n := NewNode(cs, UseTreeBitWidth(5))
+	for i := 0; i < 500; i++ {
+		if err := n.Set(ctx, fmt.Sprintf("key%d", i), i); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := n.Flush(ctx); err != nil {
+		t.Fatal(err)
+	}
+	c, err := cs.Put(ctx, n)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := LoadNode(ctx, cs, c, UseTreeBitWidth(3)); err != ErrBitWidthMismatch {
+		t.Fatalf("expected ErrBitWidthMismatch, got %v", err)
+	}
+	if _, err := LoadNode(ctx, cs, c, UseTreeBitWidth(3), UseStrictDecoding(DecodeLimits{})); err != ErrBitWidthMismatch {
+		t.Fatalf("expected ErrBitWidthMismatch with strict decoding, got %v", err)
+	}
+
+	// without the option, the width recorded in the root is used
+	for _, opts := range [][]Option{nil, {UseStrictDecoding(DecodeLimits{})}} {
+		loaded, err := LoadNode(ctx, cs, c, opts...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for i := 0; i < 500; i++ {
+			var out int
+			if err := loaded.FindInto(ctx, fmt.Sprintf("key%d", i), &out); err != nil || out != i {
+				t.Fatalf("expected %d for key%d, got %d (%v)", i, i, out, err)
+			}
+		}
+		if err := loaded.Set(ctx, "key500", 500); err != nil {
+			t.Fatal(err)
+		}
+		expected := n.Copy()
+		if err := expected.Set(ctx, "key500", 500); err != nil {
+			t.Fatal(err)
+		}
+		if !nodesEqual(t, cs, expected, loaded) {
+			t.Fatal("expected the same tree as one changed with the width it was created with")
+		}
+	}
+}
+
 func nodesEqual(t *testing.T, store *CborIpldStore, n1, n2 *Node) bool {
 	ctx := context.Background()
 	err := n1.Flush(ctx)
@@ -0,0 +1,51 @@
+package hamt
+
+// hashBits is a helper that allows the reading of the 'next n bits' of a
+// hash value as an integer.
+type hashBits struct {
+	b        []byte
+	consumed int
+}
+
+func mkmask(n int) byte {
+	return (1 << uint(n)) - 1
+}
+
+// Next returns the next 'i' bits of the hashBits value as an integer, or
+// ErrMaxDepth if there aren't enough bits left in the hash.
+func (hb *hashBits) Next(i int) (int, error) {
+	if !hb.hasNext(i) {
+		return 0, ErrMaxDepth
+	}
+	return hb.next(i), nil
+}
+
+// hasNext reports whether another 'i' bits can be read from the hash.
+func (hb *hashBits) hasNext(i int) bool {
+	return hb.consumed+i <= len(hb.b)*8
+}
+
+func (hb *hashBits) next(i int) int {
+	curbi := hb.consumed / 8
+	leftb := 8 - (hb.consumed % 8)
+
+	curb := hb.b[curbi]
+	switch {
+	case i == leftb:
+		out := int(mkmask(i) & curb)
+		hb.consumed += i
+		return out
+	case i < leftb:
+		a := curb & mkmask(leftb) // mask out the high bits we don't want
+		b := a & ^mkmask(leftb-i) // mask out the low bits we don't want
+		c := b >> uint(leftb-i)   // shift whats left down
+		hb.consumed += i
+		return int(c)
+	default:
+		out := int(mkmask(leftb) & curb)
+		out <<= uint(i - leftb)
+		hb.consumed += leftb
+		out += hb.next(i - leftb)
+		return out
+	}
+}
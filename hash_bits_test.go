@@ -0,0 +1,48 @@
+package hamt
+
+import (
+	"testing"
+)
+
+func TestBitReading(t *testing.T) {
+	hb := &hashBits{b: []byte{0xff, 0x4e, 0x12}}
+
+	expected := []struct {
+		width int
+		value int
+	}{
+		{2, 3},
+		{4, 15},
+		{3, 6},
+		{5, 19},
+		{8, 132},
+		{2, 2},
+	}
+	for _, e := range expected {
+		v, err := hb.Next(e.width)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if v != e.value {
+			t.Fatalf("expected %d from %d bits, got %d", e.value, e.width, v)
+		}
+	}
+
+	if _, err := hb.Next(1); err != ErrMaxDepth {
+		t.Fatalf("expected %q after consuming all bits, got %v", ErrMaxDepth, err)
+	}
+}
+
+func TestBitReadingFullBytes(t *testing.T) {
+	b := []byte{0x01, 0x80, 0xfe, 0x7f}
+	hb := &hashBits{b: b}
+	for i := range b {
+		v, err := hb.Next(8)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if v != int(b[i]) {
+			t.Fatalf("expected byte %d to be %d, got %d", i, b[i], v)
+		}
+	}
+}
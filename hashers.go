@@ -0,0 +1,73 @@
+package hamt
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+
+	xxhash "github.com/cespare/xxhash/v2"
+	murmur3 "github.com/spaolacci/murmur3"
+	"golang.org/x/crypto/blake2b"
+)
+
+// Hasher computes the hash that decides where a key is placed in the HAMT.
+// A hasher must be deterministic, and the length of its output bounds the
+// depth of the tree.
+type Hasher interface {
+	Hash(k string) []byte
+}
+
+// HasherFunc adapts an ordinary function to the Hasher interface.
+type HasherFunc func(k string) []byte
+
+// Hash implements the Hasher interface
+func (f HasherFunc) Hash(k string) []byte {
+	return f(k)
+}
+
+var (
+	// Murmur3Hasher uses the 128 bit murmur3 hash. It is the default.
+	Murmur3Hasher Hasher = HasherFunc(func(k string) []byte {
+		h := murmur3.New128()
+		h.Write([]byte(k))
+		return h.Sum(nil)
+	})
+
+	// SHA256Hasher uses sha2-256.
+	SHA256Hasher Hasher = HasherFunc(func(k string) []byte {
+		sum := sha256.Sum256([]byte(k))
+		return sum[:]
+	})
+
+	// Blake2bHasher uses blake2b-256.
+	Blake2bHasher Hasher = HasherFunc(func(k string) []byte {
+		sum := blake2b.Sum256([]byte(k))
+		return sum[:]
+	})
+
+	// XXHasher uses the 64 bit xxhash. It is fast but its short output
+	// limits the depth of the tree.
+	XXHasher Hasher = HasherFunc(func(k string) []byte {
+		out := make([]byte, 8)
+		binary.BigEndian.PutUint64(out, xxhash.Sum64String(k))
+		return out
+	})
+)
+
+// IdentityHasher returns a Hasher that uses the bytes of the key itself,
+// truncated or zero padded to size bytes, as its hash. It is useful for
+// tests that need to control the shape of the tree.
+func IdentityHasher(size int) Hasher {
+	return HasherFunc(func(k string) []byte {
+		res := make([]byte, size)
+		copy(res, []byte(k))
+		return res
+	})
+}
+
+// UseHasher sets the hash function used to place keys in the HAMT. The
+// same hasher must be used when loading a HAMT as when it was built.
+func UseHasher(h Hasher) Option {
+	return func(c *config) {
+		c.hasher = h
+	}
+}
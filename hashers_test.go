@@ -0,0 +1,133 @@
+package hamt
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+)
+
+func TestHashers(t *testing.T) {
+	hashers := map[string]struct {
+		h    Hasher
+		size int
+	}{
+		"murmur3":  {Murmur3Hasher, 16},
+		"sha256":   {SHA256Hasher, 32},
+		"blake2b":  {Blake2bHasher, 32},
+		"xxhash":   {XXHasher, 8},
+		"identity": {IdentityHasher(20), 20},
+	}
+
+	for name, tc := range hashers {
+		h1 := tc.h.Hash("abcd")
+		if len(h1) != tc.size {
+			t.Fatalf("%s: expected hash of %d bytes, got %d", name, tc.size, len(h1))
+		}
+		if !bytes.Equal(h1, tc.h.Hash("abcd")) {
+			t.Fatalf("%s: hash is not deterministic", name)
+		}
+		if bytes.Equal(h1, tc.h.Hash("abce")) {
+			t.Fatalf("%s: different keys should hash differently", name)
+		}
+	}
+}
+
+func TestHashersPerInstance(t *testing.T) {
+	ctx := context.Background()
+	cs := NewCborStore()
+
+	hashers := []Hasher{Murmur3Hasher, SHA256Hasher, Blake2bHasher, XXHasher}
+	roots := make([]*Node, len(hashers))
+
+	var wg sync.WaitGroup
+	for i, h := range hashers {
+		wg.Add(1)
+		go func(i int, h Hasher) {
+			defer wg.Done()
+			n := NewNode(cs, UseHasher(h))
+			for j := 0; j < 500; j++ {
+				if err := n.Set(ctx, fmt.Sprintf("key%d", j), j); err != nil {
+					t.Error(err)
+					return
+				}
+			}
+			roots[i] = n
+		}(i, h)
+	}
+	wg.Wait()
+
+	cids := make(map[string]bool)
+	for i, n := range roots {
+		if err := n.Flush(ctx); err != nil {
+			t.Fatal(err)
+		}
+		c, err := cs.Put(ctx, n)
+		if err != nil {
+			t.Fatal(err)
+		}
+		cids[c.KeyString()] = true
+
+		loaded, err := LoadNode(ctx, cs, c, UseHasher(hashers[i]))
+		if err != nil {
+			t.Fatal(err)
+		}
+		for j := 0; j < 500; j++ {
+			if _, err := loaded.Find(ctx, fmt.Sprintf("key%d", j)); err != nil {
+				t.Fatalf("hasher %d: should have found key%d: %s", i, j, err)
+			}
+		}
+	}
+	if len(cids) != len(hashers) {
+		t.Fatal("expected every hasher to produce a different tree")
+	}
+}
+
+func TestLoadedChildrenUseRootHasher(t *testing.T) {
+	ctx := context.Background()
+	cs := NewCborStore()
+
+	// keys sharing a long prefix force several levels of subshards when
+	// placed with the identity hasher
+	var keys []string
+	for i := 0; i < 20; i++ {
+		keys = append(keys, fmt.Sprintf("AAAA%02d", i))
+	}
+
+	n := NewNode(cs, UseHasher(identityHash))
+	for _, k := range keys {
+		if err := n.Set(ctx, k, k); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := n.Flush(ctx); err != nil {
+		t.Fatal(err)
+	}
+	c, err := cs.Put(ctx, n)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	loaded, err := LoadNode(ctx, cs, c, UseHasher(identityHash))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, k := range keys {
+		out, err := loaded.Find(ctx, k)
+		if err != nil {
+			t.Fatalf("should have found %s: %s", k, err)
+		}
+		if out.(string) != k {
+			t.Fatalf("expected %s, got %v", k, out)
+		}
+	}
+
+	wrong, err := LoadNode(ctx, cs, c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := wrong.Find(ctx, keys[0]); err != ErrNotFound {
+		t.Fatalf("expected a lookup with the wrong hasher to miss, got %v", err)
+	}
+}
@@ -25,6 +25,9 @@ import (
 
 type CborIpldStore struct {
 	Nodes nodes
+	// Atlas, if set, describes how values are encoded and decoded as CBOR
+	// instead of the types registered with cbor.RegisterCborType. Values
+	// holding links need it to have an entry for cid.Cid.
 	Atlas *atlas.Atlas
 }
 
@@ -46,12 +49,9 @@ func CSTFromDAG(dagservice format.DAGService) *CborIpldStore {
 }
 
 func (s *CborIpldStore) Get(ctx context.Context, c cid.Cid, out interface{}) error {
-	ctx, cancel := context.WithTimeout(ctx, time.Second*10)
-	defer cancel()
-
-	blk, err := s.Nodes.Get(ctx, c)
+	blk, err := s.getBlock(ctx, c)
 	if err != nil {
-		return fmt.Errorf("error getting: %v", err)
+		return err
 	}
 
 	switch c.Type() {
@@ -62,8 +62,20 @@ func (s *CborIpldStore) Get(ctx context.Context, c cid.Cid, out interface{}) err
 		}
 		return goipldpb.DecodeInto(blk.RawData(), msg)
 	default:
-		return cbor.DecodeInto(blk.RawData(), out)
+		return s.valueCodec().Decode(blk.RawData(), out)
+	}
+}
+
+// getBlock returns the block c.
+func (s *CborIpldStore) getBlock(ctx context.Context, c cid.Cid) (format.Node, error) {
+	ctx, cancel := context.WithTimeout(ctx, time.Second*10)
+	defer cancel()
+
+	blk, err := s.Nodes.Get(ctx, c)
+	if err != nil {
+		return nil, fmt.Errorf("error getting: %w", err)
 	}
+	return blk, nil
 }
 
 type cidProvider interface {
@@ -80,10 +92,7 @@ func (s *CborIpldStore) Put(ctx context.Context, v interface{}) (cid.Cid, error)
 	case proto.Message:
 		nd, err = goipldpb.WrapObject(msg)
 	default:
-		nd, err = WrapObject(v)
-		if err != nil {
-			return cid.Undef, err
-		}
+		nd, err = s.wrapValue(v)
 	}
 	if err != nil {
 		return cid.Undef, err
@@ -95,6 +104,26 @@ func (s *CborIpldStore) Put(ctx context.Context, v interface{}) (cid.Cid, error)
 	return nd.Cid(), nil
 }
 
+// wrapValue is WrapObject using the Atlas of the store.
+func (s *CborIpldStore) wrapValue(v interface{}) (format.Node, error) {
+	if s == nil || s.Atlas == nil {
+		return WrapObject(v)
+	}
+	data, err := s.valueCodec().Encode(v)
+	if err != nil {
+		return nil, err
+	}
+	return cbor.Decode(data, mhType, mhLen)
+}
+
+// valueCodec returns the codec of the CBOR objects in the store.
+func (s *CborIpldStore) valueCodec() Codec {
+	if s == nil {
+		return CBORCodec(nil)
+	}
+	return CBORCodec(s.Atlas)
+}
+
 func WrapObject(v interface{}) (format.Node, error) {
 	nd, err := cbor.WrapObject(v, mhType, mhLen)
 	if err != nil {
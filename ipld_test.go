@@ -1,9 +1,12 @@
 package hamt
 
 import (
+	"bytes"
 	"context"
+	"reflect"
 	"testing"
 
+	atlas "github.com/polydawn/refmt/obj/atlas"
 	"github.com/quorumcontrol/go-hamt-ipld/pb"
 )
 
@@ -49,3 +52,69 @@ func TestRoundtrip(t *testing.T) {
 		t.Fatal("cid mismatch")
 	}
 }
+
+type atlasPerson struct {
+	Name    string
+	Age     int
+	Friends []string
+}
+
+func TestFindIntoWithAtlas(t *testing.T) {
+	ctx := context.Background()
+
+	atl := atlas.MustBuild(
+		atlas.BuildEntry(atlasPerson{}).StructMap().Autogenerate().Complete(),
+	)
+	cs := NewCborStore()
+	cs.Atlas = &atl
+
+	n := NewNode(cs)
+	alice := atlasPerson{Name: "alice", Age: 30, Friends: []string{"bob"}}
+	if err := n.Set(ctx, "alice", alice); err != nil {
+		t.Fatal(err)
+	}
+	if err := n.Set(ctx, "plain", map[string]interface{}{"b": 2, "a": 1}); err != nil {
+		t.Fatal(err)
+	}
+
+	var out atlasPerson
+	if err := n.FindInto(ctx, "alice", &out); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(out, alice) {
+		t.Fatalf("expected %v, got %v", alice, out)
+	}
+	if err := n.FindInto(ctx, "missing", &out); err != ErrNotFound {
+		t.Fatalf("expected ErrNotFound, got %v", err)
+	}
+
+	// values without an atlas entry encode exactly as they do without an atlas
+	kv, err := n.GetKV(ctx, "plain")
+	if err != nil {
+		t.Fatal(err)
+	}
+	nd, err := WrapObject(map[string]interface{}{"a": 1, "b": 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(kv.Value, nd.RawData()) {
+		t.Fatal("expected the atlas to keep the canonical encoding of maps")
+	}
+
+	// the struct is only known to the atlas of the store
+	if err := NewNode(NewCborStore()).Set(ctx, "alice", alice); err == nil {
+		t.Fatal("expected encoding an unregistered struct without an atlas to fail")
+	}
+
+	c, err := cs.Put(ctx, alice)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got atlasPerson
+	if err := cs.Get(ctx, c, &got); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, alice) {
+		t.Fatalf("expected %v from the store, got %v", alice, got)
+	}
+}
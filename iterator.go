@@ -0,0 +1,254 @@
+package hamt
+
+import (
+	"bytes"
+	"context"
+	"sort"
+
+	"github.com/quorumcontrol/go-hamt-ipld/pb"
+)
+
+// ReadOption configures ForEach, Iterator, AllPairs and Page.
+type ReadOption func(*readConfig)
+
+type readConfig struct {
+	keepValueLinks bool
+}
+
+func newReadConfig(opts ...ReadOption) *readConfig {
+	conf := &readConfig{}
+	for _, o := range opts {
+		o(conf)
+	}
+	return conf
+}
+
+// KeepValueLinks returns the pairs as they are stored: values stored outside
+// of their node are left as links rather than read back from the store, like
+// GetKV does.
+func KeepValueLinks() ReadOption {
+	return func(c *readConfig) {
+		c.keepValueLinks = true
+	}
+}
+
+// ForEach calls f with every key/value pair in the HAMT, in the order of the
+// hashes of their keys, compared from the most significant bit as the tree
+// consumes them, then of the keys themselves for keys with the same hash.
+// Children that aren't already cached are only held for the duration of
+// their visit, so memory use is bounded by the depth of the tree rather than
+// its size. If f returns an error the walk stops and the error is returned.
+//
+// Every node is read atomically, but writes made while the walk is in
+// progress may or may not be seen.
+func (n *Node) ForEach(ctx context.Context, f func(kv *pb.KV) error, opts ...ReadOption) error {
+	if newReadConfig(opts...).keepValueLinks {
+		return n.forEach(ctx, f)
+	}
+	return n.forEach(ctx, func(kv *pb.KV) error {
+		kv, err := n.resolveKV(ctx, kv)
+		if err != nil {
+			return err
+		}
+		return f(kv)
+	})
+}
+
+// forEach calls f with every pair as it is stored.
+func (n *Node) forEach(ctx context.Context, f func(kv *pb.KV) error) error {
+	nd := n.shallowCopy()
+	for _, p := range nd.Pointers {
+		if p.isShard() {
+			chnd, err := p.peekChild(ctx, nd)
+			if err != nil {
+				return err
+			}
+			if err := chnd.forEach(ctx, f); err != nil {
+				return err
+			}
+			continue
+		}
+
+		for _, kv := range nd.hashOrder(p.Kvs) {
+			if err := f(kv); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// hashOrder returns the pairs of a bucket in the order ForEach visits them.
+// Buckets are stored sorted by key, and hold keys whose hashes only share the
+// bits consumed above the bucket, so they are sorted again by hash.
+func (n *Node) hashOrder(kvs []*pb.KV) []*pb.KV {
+	if len(kvs) < 2 {
+		return kvs
+	}
+	type entry struct {
+		kv   *pb.KV
+		hash []byte
+	}
+	entries := make([]entry, len(kvs))
+	for i, kv := range kvs {
+		entries[i] = entry{kv: kv, hash: n.hashKey(kv.Key)}
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		if c := bytes.Compare(entries[i].hash, entries[j].hash); c != 0 {
+			return c < 0
+		}
+		return entries[i].kv.Key < entries[j].kv.Key
+	})
+
+	out := make([]*pb.KV, len(kvs))
+	for i, e := range entries {
+		out[i] = e.kv
+	}
+	return out
+}
+
+// peekChild returns the node the pointer links to like loadChild, but
+// doesn't keep a freshly loaded node in the pointer cache. It is meant for
+// the pointers of a shallowCopy, which are not shared.
+func (p *Pointer) peekChild(ctx context.Context, parent *Node) (*Node, error) {
+	if p.cache != nil {
+		return p.cache, nil
+	}
+	return loadNode(ctx, parent.store, p.Link(), parent.config, parent.depth+1)
+}
+
+// shallowCopy returns a copy of n that can be read without holding any lock.
+// Buckets are never modified in place, and child nodes are shared rather
+// than copied.
+func (n *Node) shallowCopy() *Node {
+	n.mu.RLock()
+	defer n.mu.RUnlock()
+
+	nn := newNode(n.store, n.config)
+	nn.Bitfield.Set(n.Bitfield)
+	nn.count = n.count
+	nn.uncounted = n.uncounted
+	nn.bitWidth = n.bitWidth
+	nn.depth = n.depth
+	nn.Pointers = make(pointerSlice, len(n.Pointers))
+	for i, p := range n.Pointers {
+		nn.Pointers[i] = &Pointer{
+			Pointer: &pb.Pointer{LinkBits: p.LinkBits, Kvs: p.Kvs},
+			cache:   p.cache,
+		}
+	}
+	return nn
+}
+
+// Iterator walks the key/value pairs of a HAMT one at a time, in the same
+// order and with the same consistency as ForEach.
+//
+//	it := n.Iterator(ctx)
+//	for it.Next() {
+//		fmt.Println(it.Key(), it.Value())
+//	}
+//	if err := it.Err(); err != nil {
+//		...
+//	}
+type Iterator struct {
+	ctx            context.Context
+	keepValueLinks bool
+	stack          []*iterFrame
+	kv             *pb.KV
+	err            error
+}
+
+// iterFrame is the position of the iterator within one node of the path
+// from the root to the current pair.
+type iterFrame struct {
+	node *Node
+	// index of the pointer being visited; for every frame but the last this
+	// is the shard the next frame was loaded from
+	pi int
+	// index of the next pair to return from bucket, the pairs of the bucket
+	// at pi in hash order, which is set once the bucket is reached
+	ki     int
+	bucket []*pb.KV
+}
+
+// Iterator returns an iterator positioned before the first pair in the HAMT.
+func (n *Node) Iterator(ctx context.Context, opts ...ReadOption) *Iterator {
+	return &Iterator{
+		ctx:            ctx,
+		keepValueLinks: newReadConfig(opts...).keepValueLinks,
+		stack:          []*iterFrame{{node: n.shallowCopy()}},
+	}
+}
+
+// Next advances the iterator to the next pair, returning false when there
+// are no pairs left or an error occurred.
+func (it *Iterator) Next() bool {
+	it.kv = nil
+	for it.err == nil && len(it.stack) > 0 {
+		f := it.stack[len(it.stack)-1]
+		if f.pi >= len(f.node.Pointers) {
+			it.stack = it.stack[:len(it.stack)-1]
+			if len(it.stack) > 0 {
+				// move the parent past the shard we just finished
+				it.stack[len(it.stack)-1].pi++
+			}
+			continue
+		}
+
+		p := f.node.Pointers[f.pi]
+		if p.isShard() {
+			chnd, err := p.peekChild(it.ctx, f.node)
+			if err != nil {
+				it.err = err
+				return false
+			}
+			it.stack = append(it.stack, &iterFrame{node: chnd.shallowCopy()})
+			continue
+		}
+
+		if f.bucket == nil {
+			f.bucket = f.node.hashOrder(p.Kvs)
+		}
+		if f.ki >= len(f.bucket) {
+			f.pi++
+			f.ki = 0
+			f.bucket = nil
+			continue
+		}
+
+		kv := f.bucket[f.ki]
+		f.ki++
+		if !it.keepValueLinks {
+			resolved, err := f.node.resolveKV(it.ctx, kv)
+			if err != nil {
+				it.err = err
+				return false
+			}
+			kv = resolved
+		}
+		it.kv = kv
+		return true
+	}
+	return false
+}
+
+// KV returns the current pair.
+func (it *Iterator) KV() *pb.KV {
+	return it.kv
+}
+
+// Key returns the key of the current pair.
+func (it *Iterator) Key() string {
+	return it.kv.Key
+}
+
+// Value returns the encoded value of the current pair. With KeepValueLinks
+// it is nil for a value stored outside of its node, whose link is in KV.
+func (it *Iterator) Value() []byte {
+	return it.kv.Value
+}
+
+// Err returns the error, if any, that stopped the iteration.
+func (it *Iterator) Err() error {
+	return it.err
+}
@@ -0,0 +1,162 @@
+package hamt
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/quorumcontrol/go-hamt-ipld/pb"
+)
+
+func buildFlushedHamt(t *testing.T, count int, opts ...Option) (*CborIpldStore, *Node, map[string][]byte) {
+	ctx := context.Background()
+	cs := NewCborStore()
+	n := NewNode(cs, opts...)
+	vals := make(map[string][]byte)
+	for i := 0; i < count; i++ {
+		k := randString()
+		vals[k] = randValue()
+		if err := n.Set(ctx, k, vals[k]); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := n.Flush(ctx); err != nil {
+		t.Fatal(err)
+	}
+	c, err := cs.Put(ctx, n)
+	if err != nil {
+		t.Fatal(err)
+	}
+	loaded, err := LoadNode(ctx, cs, c, opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cs, loaded, vals
+}
+
+func TestForEach(t *testing.T) {
+	ctx := context.Background()
+	_, n, vals := buildFlushedHamt(t, 2000)
+
+	var order []string
+	seen := make(map[string]bool)
+	err := n.ForEach(ctx, func(kv *pb.KV) error {
+		if seen[kv.Key] {
+			return fmt.Errorf("visited %s twice", kv.Key)
+		}
+		v, ok := vals[kv.Key]
+		if !ok {
+			return fmt.Errorf("visited unknown key %s", kv.Key)
+		}
+		var out []byte
+		if err := n.decodeKV(kv, &out); err != nil {
+			return err
+		}
+		if !bytes.Equal(out, v) {
+			return fmt.Errorf("wrong value for %s", kv.Key)
+		}
+		seen[kv.Key] = true
+		order = append(order, kv.Key)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(seen) != len(vals) {
+		t.Fatalf("expected to visit %d pairs, visited %d", len(vals), len(seen))
+	}
+
+	// pairs come in the order of their hashes, read from the most
+	// significant bit down as the tree consumes them, then of their keys
+	hash := n.conf().hasher.Hash
+	for i := 1; i < len(order); i++ {
+		prev, cur := order[i-1], order[i]
+		c := bytes.Compare(hash(prev), hash(cur))
+		if c > 0 || c == 0 && prev >= cur {
+			t.Fatalf("expected %s to come before %s", cur, prev)
+		}
+	}
+
+	for _, p := range n.Pointers {
+		if p.cache != nil {
+			t.Fatal("ForEach should not cache the children it visits")
+		}
+	}
+
+	i := 0
+	err = n.ForEach(ctx, func(kv *pb.KV) error {
+		if order[i] != kv.Key {
+			return fmt.Errorf("expected %s at position %d, got %s", order[i], i, kv.Key)
+		}
+		i++
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestForEachStopsOnError(t *testing.T) {
+	ctx := context.Background()
+	_, n, _ := buildFlushedHamt(t, 500)
+
+	stop := fmt.Errorf("stop")
+	visited := 0
+	err := n.ForEach(ctx, func(kv *pb.KV) error {
+		visited++
+		if visited == 10 {
+			return stop
+		}
+		return nil
+	})
+	if err != stop {
+		t.Fatalf("expected the callback error, got %v", err)
+	}
+	if visited != 10 {
+		t.Fatalf("expected the walk to stop after 10 pairs, visited %d", visited)
+	}
+}
+
+func TestIterator(t *testing.T) {
+	ctx := context.Background()
+	_, n, vals := buildFlushedHamt(t, 2000, UseTreeBitWidth(4))
+
+	pairs, err := n.AllPairs(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pairs) != len(vals) {
+		t.Fatalf("expected %d pairs, got %d", len(vals), len(pairs))
+	}
+
+	it := n.Iterator(ctx)
+	i := 0
+	for it.Next() {
+		if it.Key() != pairs[i].Key || !it.KV().Equals(pairs[i]) {
+			t.Fatalf("iterator and AllPairs disagree at position %d", i)
+		}
+		var out []byte
+		if err := n.decodeKV(it.KV(), &out); err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(out, vals[it.Key()]) {
+			t.Fatalf("wrong value for %s", it.Key())
+		}
+		i++
+	}
+	if err := it.Err(); err != nil {
+		t.Fatal(err)
+	}
+	if i != len(vals) {
+		t.Fatalf("expected to iterate over %d pairs, got %d", len(vals), i)
+	}
+	if it.Next() {
+		t.Fatal("exhausted iterator should stay exhausted")
+	}
+
+	empty := NewNode(NewCborStore()).Iterator(ctx)
+	if empty.Next() || empty.Err() != nil {
+		t.Fatal("expected an empty iterator")
+	}
+}
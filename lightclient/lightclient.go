@@ -0,0 +1,121 @@
+// Package lightclient reads a HAMT from a source that isn't trusted, knowing
+// nothing but the CID of its root. Every block is checked against the CID it
+// was reached by before it is used, so whatever the source sends, reads
+// either return what the HAMT under the root holds or fail with ErrTampered.
+package lightclient
+
+import (
+	"context"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+
+	blocks "github.com/ipfs/go-block-format"
+	cid "github.com/ipfs/go-cid"
+	format "github.com/ipfs/go-ipld-format"
+	"github.com/quorumcontrol/go-hamt-ipld"
+	"golang.org/x/xerrors"
+)
+
+// ErrTampered is returned, possibly wrapped, when the source sends a block
+// that doesn't match its CID.
+var ErrTampered = xerrors.New("block does not match its CID")
+
+// ErrReadOnly is returned when something tries to write through the store of
+// a light client.
+var ErrReadOnly = xerrors.New("light client store is read-only")
+
+// BlockFetcher fetches the raw data of blocks. It doesn't have to be
+// trusted.
+type BlockFetcher interface {
+	FetchBlock(ctx context.Context, c cid.Cid) ([]byte, error)
+}
+
+// Load returns the HAMT stored under root, reading it through f. Find,
+// ForEach and the other read methods of the HAMT return verified results;
+// changes can be made locally but not flushed. Nodes are decoded strictly,
+// with the default limits unless the options ask for others.
+func Load(ctx context.Context, f BlockFetcher, root cid.Cid, opts ...hamt.Option) (*hamt.Node, error) {
+	opts = append([]hamt.Option{hamt.UseStrictDecoding(hamt.DecodeLimits{})}, opts...)
+	return hamt.LoadNode(ctx, NewStore(f), root, opts...)
+}
+
+// NewStore returns a read-only store that reads blocks through f and checks
+// them before returning them.
+func NewStore(f BlockFetcher) *hamt.CborIpldStore {
+	return &hamt.CborIpldStore{Nodes: &verifyingNodes{fetcher: f}}
+}
+
+type verifyingNodes struct {
+	fetcher BlockFetcher
+}
+
+func (vn *verifyingNodes) Get(ctx context.Context, c cid.Cid) (format.Node, error) {
+	data, err := vn.fetcher.FetchBlock(ctx, c)
+	if err != nil {
+		return nil, xerrors.Errorf("error fetching %s: %w", c, err)
+	}
+	sum, err := c.Prefix().Sum(data)
+	if err != nil {
+		return nil, xerrors.Errorf("error hashing %s: %w", c, err)
+	}
+	if !sum.Equals(c) {
+		return nil, xerrors.Errorf("block %s: %w", c, ErrTampered)
+	}
+
+	blk, err := blocks.NewBlockWithCid(data, c)
+	if err != nil {
+		return nil, xerrors.Errorf("error creating block: %w", err)
+	}
+	return format.Decode(blk)
+}
+
+func (vn *verifyingNodes) Add(context.Context, format.Node) error {
+	return ErrReadOnly
+}
+
+// DefaultMaxBlockSize is the largest block an HTTPFetcher reads when its
+// MaxBlockSize isn't set.
+const DefaultMaxBlockSize = 16 << 20
+
+// HTTPFetcher fetches blocks with a GET of BaseURL followed by a slash and
+// the CID of the block. Bodies are read up to MaxBlockSize bytes.
+type HTTPFetcher struct {
+	BaseURL string
+	// Client defaults to http.DefaultClient.
+	Client       *http.Client
+	MaxBlockSize int
+}
+
+func (hf *HTTPFetcher) FetchBlock(ctx context.Context, c cid.Cid) ([]byte, error) {
+	req, err := http.NewRequest(http.MethodGet, strings.TrimSuffix(hf.BaseURL, "/")+"/"+c.String(), nil)
+	if err != nil {
+		return nil, xerrors.Errorf("error creating request: %w", err)
+	}
+	client := hf.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req.WithContext(ctx))
+	if err != nil {
+		return nil, xerrors.Errorf("error requesting block: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, xerrors.Errorf("unexpected response: %s", resp.Status)
+	}
+
+	max := hf.MaxBlockSize
+	if max == 0 {
+		max = DefaultMaxBlockSize
+	}
+	data, err := ioutil.ReadAll(io.LimitReader(resp.Body, int64(max)+1))
+	if err != nil {
+		return nil, xerrors.Errorf("error reading block: %w", err)
+	}
+	if len(data) > max {
+		return nil, xerrors.Errorf("block larger than %d bytes", max)
+	}
+	return data, nil
+}
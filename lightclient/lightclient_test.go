@@ -0,0 +1,151 @@
+package lightclient
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	cid "github.com/ipfs/go-cid"
+	"github.com/ipfs/go-merkledag"
+	"github.com/quorumcontrol/go-hamt-ipld"
+	"github.com/quorumcontrol/go-hamt-ipld/pb"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/xerrors"
+)
+
+// blockServer serves the blocks of a store, and can be told to corrupt some
+// of them.
+type blockServer struct {
+	store *hamt.CborIpldStore
+
+	mu      sync.Mutex
+	tampers map[string]bool
+}
+
+func (bs *blockServer) tamper(c cid.Cid) {
+	bs.mu.Lock()
+	defer bs.mu.Unlock()
+	bs.tampers[c.String()] = true
+}
+
+func (bs *blockServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	c, err := cid.Decode(strings.TrimPrefix(r.URL.Path, "/"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	nd, err := bs.store.Nodes.Get(r.Context(), c)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	data := append([]byte(nil), nd.RawData()...)
+
+	bs.mu.Lock()
+	if bs.tampers[c.String()] {
+		data[len(data)-1] ^= 0xff
+	}
+	bs.mu.Unlock()
+	w.Write(data)
+}
+
+// setup serves a HAMT, the returned func stops the server.
+func setup(t *testing.T, opts ...hamt.Option) (*blockServer, *HTTPFetcher, cid.Cid, func()) {
+	ctx := context.Background()
+	cs := hamt.NewCborStore()
+	n := hamt.NewNode(cs, opts...)
+	for i := 0; i < 500; i++ {
+		require.Nil(t, n.Set(ctx, fmt.Sprintf("key%d", i), i))
+	}
+	require.Nil(t, n.SetRaw(ctx, "big", bytes.Repeat([]byte("big value "), 100)))
+	require.Nil(t, n.Flush(ctx))
+	root, err := cs.Put(ctx, n)
+	require.Nil(t, err)
+
+	bs := &blockServer{store: cs, tampers: make(map[string]bool)}
+	srv := httptest.NewServer(bs)
+	return bs, &HTTPFetcher{BaseURL: srv.URL}, root, srv.Close
+}
+
+func TestVerifiedReads(t *testing.T) {
+	ctx := context.Background()
+	opts := []hamt.Option{hamt.UseTreeBitWidth(4), hamt.UseValueThreshold(100), hamt.UseValueChunkSize(64)}
+	_, fetcher, root, stop := setup(t, opts...)
+	defer stop()
+
+	n, err := Load(ctx, fetcher, root, opts...)
+	require.Nil(t, err)
+
+	for i := 0; i < 500; i += 13 {
+		var out int
+		require.Nil(t, n.FindInto(ctx, fmt.Sprintf("key%d", i), &out))
+		require.Equal(t, i, out)
+	}
+	_, err = n.Find(ctx, "missing")
+	require.Equal(t, hamt.ErrNotFound, err)
+
+	big, err := n.FindRaw(ctx, "big")
+	require.Nil(t, err)
+	require.Equal(t, bytes.Repeat([]byte("big value "), 100), big)
+
+	count := 0
+	require.Nil(t, n.ForEach(ctx, func(*pb.KV) error {
+		count++
+		return nil
+	}))
+	require.Equal(t, 501, count)
+
+	err = n.Flush(ctx)
+	require.True(t, xerrors.Is(err, ErrReadOnly), "expected ErrReadOnly, got %v", err)
+}
+
+func TestTamperedBlocks(t *testing.T) {
+	ctx := context.Background()
+	opts := []hamt.Option{hamt.UseTreeBitWidth(4)}
+	server, fetcher, root, stop := setup(t, opts...)
+	defer stop()
+
+	n, err := Load(ctx, fetcher, root, opts...)
+	require.Nil(t, err)
+	for _, p := range n.Pointers {
+		if p.Link().Defined() {
+			server.tamper(p.Link())
+		}
+	}
+
+	err = n.ForEach(ctx, func(*pb.KV) error { return nil })
+	require.True(t, xerrors.Is(err, ErrTampered), "expected ErrTampered, got %v", err)
+
+	tampered := 0
+	for i := 0; i < 500; i++ {
+		_, err := n.Find(ctx, fmt.Sprintf("key%d", i))
+		if err != nil {
+			require.True(t, xerrors.Is(err, ErrTampered), "expected ErrTampered, got %v", err)
+			tampered++
+		}
+	}
+	require.NotZero(t, tampered)
+
+	server.tamper(root)
+	_, err = Load(ctx, fetcher, root, opts...)
+	require.True(t, xerrors.Is(err, ErrTampered), "expected ErrTampered, got %v", err)
+}
+
+func TestHTTPFetcherLimits(t *testing.T) {
+	ctx := context.Background()
+	_, fetcher, root, stop := setup(t)
+	defer stop()
+
+	fetcher.MaxBlockSize = 10
+	_, err := fetcher.FetchBlock(ctx, root)
+	require.NotNil(t, err)
+
+	fetcher.MaxBlockSize = 0
+	_, err = fetcher.FetchBlock(ctx, merkledag.NewRawNode([]byte("missing")).Cid())
+	require.NotNil(t, err)
+}
@@ -0,0 +1,159 @@
+package hamt
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"math"
+
+	"github.com/quorumcontrol/go-hamt-ipld/pb"
+)
+
+// ErrInvalidCursor is returned by Page when the cursor is malformed or
+// doesn't point at an entry of the HAMT it is used with.
+var ErrInvalidCursor = fmt.Errorf("invalid cursor")
+
+// Page returns up to limit pairs starting at cursor, in the same order as
+// ForEach, along with the cursor to pass in to fetch the next page. A nil
+// cursor starts from the beginning and a nil returned cursor means there
+// are no pairs left.
+//
+// A cursor records the hash path and bucket position of the next pair, so
+// it is only meaningful for the root it was returned from: continuing from
+// it against the same root never skips or repeats a pair.
+func (n *Node) Page(ctx context.Context, cursor []byte, limit int, opts ...ReadOption) ([]*pb.KV, []byte, error) {
+	if limit < 1 {
+		return nil, nil, fmt.Errorf("invalid page limit %d", limit)
+	}
+
+	it := n.Iterator(ctx, opts...)
+	if cursor != nil {
+		path, ki, err := decodeCursor(cursor)
+		if err != nil {
+			return nil, nil, err
+		}
+		if it, err = n.iteratorAt(ctx, path, ki, opts...); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	kvs := make([]*pb.KV, 0, limit)
+	for len(kvs) < limit && it.Next() {
+		kvs = append(kvs, it.KV())
+	}
+	if it.Err() != nil {
+		return nil, nil, it.Err()
+	}
+
+	if !it.Next() {
+		if it.Err() != nil {
+			return nil, nil, it.Err()
+		}
+		return kvs, nil, nil
+	}
+	path, ki := it.position()
+	return kvs, encodeCursor(path, ki), nil
+}
+
+// position returns the hash path (the bit position taken at every level) to
+// the bucket holding the current pair, and the index of the pair within it
+// in hash order.
+func (it *Iterator) position() ([]int, int) {
+	path := make([]int, len(it.stack))
+	for i, f := range it.stack {
+		path[i] = f.node.bitPosForIndex(f.pi)
+	}
+	return path, it.stack[len(it.stack)-1].ki - 1
+}
+
+// iteratorAt returns an iterator whose next pair is the pair at index ki, in
+// hash order, of the bucket reached by following path.
+func (n *Node) iteratorAt(ctx context.Context, path []int, ki int, opts ...ReadOption) (*Iterator, error) {
+	it := &Iterator{ctx: ctx, keepValueLinks: newReadConfig(opts...).keepValueLinks}
+	nd := n.shallowCopy()
+	for depth, bp := range path {
+		if bp < 0 || nd.Bitfield.Bit(bp) == 0 {
+			return nil, ErrInvalidCursor
+		}
+		f := &iterFrame{node: nd, pi: nd.indexForBitPos(bp)}
+		it.stack = append(it.stack, f)
+
+		p := nd.getChild(byte(f.pi))
+		if p == nil {
+			return nil, ErrMalformedNode
+		}
+		if depth == len(path)-1 {
+			if p.isShard() || ki >= len(p.Kvs) {
+				return nil, ErrInvalidCursor
+			}
+			f.ki = ki
+			f.bucket = nd.hashOrder(p.Kvs)
+			return it, nil
+		}
+
+		if !p.isShard() {
+			return nil, ErrInvalidCursor
+		}
+		chnd, err := p.peekChild(ctx, nd)
+		if err != nil {
+			return nil, err
+		}
+		nd = chnd.shallowCopy()
+	}
+	return nil, ErrInvalidCursor
+}
+
+// encodeCursor serializes a position as the number of levels in the path,
+// followed by the bit position at each level and the index in the bucket,
+// all as uvarints.
+func encodeCursor(path []int, ki int) []byte {
+	buf := make([]byte, 0, (len(path)+2)*binary.MaxVarintLen16)
+	tmp := make([]byte, binary.MaxVarintLen64)
+
+	put := func(v int) {
+		l := binary.PutUvarint(tmp, uint64(v))
+		buf = append(buf, tmp[:l]...)
+	}
+
+	put(len(path))
+	for _, bp := range path {
+		put(bp)
+	}
+	put(ki)
+	return buf
+}
+
+func decodeCursor(cursor []byte) ([]int, int, error) {
+	get := func() (int, error) {
+		v, l := binary.Uvarint(cursor)
+		if l <= 0 || v > math.MaxInt32 {
+			return 0, ErrInvalidCursor
+		}
+		cursor = cursor[l:]
+		return int(v), nil
+	}
+
+	depth, err := get()
+	if err != nil {
+		return nil, 0, err
+	}
+	// every level takes at least a byte, plus one for the bucket index
+	if depth == 0 || depth >= len(cursor) {
+		return nil, 0, ErrInvalidCursor
+	}
+
+	path := make([]int, depth)
+	for i := range path {
+		if path[i], err = get(); err != nil {
+			return nil, 0, err
+		}
+	}
+	ki, err := get()
+	if err != nil {
+		return nil, 0, err
+	}
+	if len(cursor) != 0 {
+		return nil, 0, ErrInvalidCursor
+	}
+	return path, ki, nil
+}
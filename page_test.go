@@ -0,0 +1,120 @@
+package hamt
+
+import (
+	"context"
+	"testing"
+
+	"github.com/quorumcontrol/go-hamt-ipld/pb"
+)
+
+func TestPage(t *testing.T) {
+	ctx := context.Background()
+	cs, n, vals := buildFlushedHamt(t, 2000, UseTreeBitWidth(5))
+
+	all, err := n.AllPairs(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	root, err := cs.Put(ctx, n)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, limit := range []int{1, 7, 100, 5000} {
+		var got []*pb.KV
+		var cursor []byte
+		for {
+			// every page is served from a freshly loaded root, as it
+			// would be across requests
+			nd, err := LoadNode(ctx, cs, root, UseTreeBitWidth(5))
+			if err != nil {
+				t.Fatal(err)
+			}
+			kvs, next, err := nd.Page(ctx, cursor, limit)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(kvs) > limit {
+				t.Fatalf("page of %d pairs exceeds limit %d", len(kvs), limit)
+			}
+			got = append(got, kvs...)
+			if next == nil {
+				break
+			}
+			if len(kvs) != limit {
+				t.Fatalf("expected a full page before the last one, got %d pairs", len(kvs))
+			}
+			cursor = next
+		}
+
+		if len(got) != len(vals) {
+			t.Fatalf("limit %d: expected %d pairs, got %d", limit, len(vals), len(got))
+		}
+		for i := range got {
+			if !got[i].Equals(all[i]) {
+				t.Fatalf("limit %d: pages disagree with AllPairs at position %d", limit, i)
+			}
+		}
+	}
+}
+
+func TestPageCollisionBucket(t *testing.T) {
+	ctx := context.Background()
+	cs := NewCborStore()
+	n := NewNode(cs, UseHasher(HasherFunc(func(k string) []byte {
+		return []byte{0x01}
+	})))
+	for _, k := range []string{"a", "b", "c", "d", "e", "f", "g"} {
+		if err := n.Set(ctx, k, k); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var keys []string
+	var cursor []byte
+	for {
+		kvs, next, err := n.Page(ctx, cursor, 2)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, kv := range kvs {
+			keys = append(keys, kv.Key)
+		}
+		if next == nil {
+			break
+		}
+		cursor = next
+	}
+	if len(keys) != 7 || keys[0] != "a" || keys[6] != "g" {
+		t.Fatalf("unexpected keys from collision bucket: %v", keys)
+	}
+}
+
+func TestPageInvalidCursor(t *testing.T) {
+	ctx := context.Background()
+	_, n, _ := buildFlushedHamt(t, 50)
+
+	if _, _, err := n.Page(ctx, nil, 0); err == nil {
+		t.Fatal("expected an error for a zero limit")
+	}
+
+	for _, cursor := range [][]byte{
+		{},
+		{0x01},
+		{0xff, 0xff, 0xff},
+		encodeCursor([]int{300}, 0),
+		encodeCursor([]int{n.bitPosForIndex(0)}, 99),
+		append(encodeCursor([]int{n.bitPosForIndex(0)}, 0), 0x00),
+	} {
+		if _, _, err := n.Page(ctx, cursor, 10); err != ErrInvalidCursor {
+			t.Fatalf("expected ErrInvalidCursor for %x, got %v", cursor, err)
+		}
+	}
+
+	empty := NewNode(NewCborStore())
+	kvs, next, err := empty.Page(ctx, nil, 10)
+	if err != nil || len(kvs) != 0 || next != nil {
+		t.Fatal("expected an empty page with no cursor from an empty HAMT")
+	}
+}
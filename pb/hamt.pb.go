@@ -27,8 +27,10 @@ var _ = math.Inf
 const _ = proto.GoGoProtoPackageIsVersion2 // please upgrade the proto package
 
 type KV struct {
-	Key   string `protobuf:"bytes,1,opt,name=key,proto3" json:"key,omitempty"`
-	Value []byte `protobuf:"bytes,2,opt,name=value,proto3" json:"value,omitempty"`
+	Key       string `protobuf:"bytes,1,opt,name=key,proto3" json:"key,omitempty"`
+	Value     []byte `protobuf:"bytes,2,opt,name=value,proto3" json:"value,omitempty"`
+	Codec     uint64 `protobuf:"varint,3,opt,name=codec,proto3" json:"codec,omitempty"`
+	ValueLink []byte `protobuf:"bytes,4,opt,name=value_link,json=valueLink,proto3" json:"value_link,omitempty"`
 }
 
 func (m *KV) Reset()      { *m = KV{} }
@@ -73,6 +75,20 @@ func (m *KV) GetValue() []byte {
 	return nil
 }
 
+func (m *KV) GetCodec() uint64 {
+	if m != nil {
+		return m.Codec
+	}
+	return 0
+}
+
+func (m *KV) GetValueLink() []byte {
+	if m != nil {
+		return m.ValueLink
+	}
+	return nil
+}
+
 type Pointer struct {
 	LinkBits []byte `protobuf:"bytes,1,opt,name=link_bits,json=linkBits,proto3" json:"link_bits,omitempty"`
 	Kvs      []*KV  `protobuf:"bytes,2,rep,name=kvs,proto3" json:"kvs,omitempty"`
@@ -123,6 +139,8 @@ func (m *Pointer) GetKvs() []*KV {
 type Node struct {
 	Bitfield []byte     `protobuf:"bytes,1,opt,name=bitfield,proto3" json:"bitfield,omitempty"`
 	Pointers []*Pointer `protobuf:"bytes,2,rep,name=pointers,proto3" json:"pointers,omitempty"`
+	Count    uint64     `protobuf:"varint,3,opt,name=count,proto3" json:"count,omitempty"`
+	BitWidth uint32     `protobuf:"varint,4,opt,name=bit_width,json=bitWidth,proto3" json:"bit_width,omitempty"`
 }
 
 func (m *Node) Reset()      { *m = Node{} }
@@ -167,35 +185,94 @@ func (m *Node) GetPointers() []*Pointer {
 	return nil
 }
 
+func (m *Node) GetCount() uint64 {
+	if m != nil {
+		return m.Count
+	}
+	return 0
+}
+
+func (m *Node) GetBitWidth() uint32 {
+	if m != nil {
+		return m.BitWidth
+	}
+	return 0
+}
+
+type Proof struct {
+	Blocks [][]byte `protobuf:"bytes,1,rep,name=blocks,proto3" json:"blocks,omitempty"`
+}
+
+func (m *Proof) Reset()      { *m = Proof{} }
+func (*Proof) ProtoMessage() {}
+func (*Proof) Descriptor() ([]byte, []int) {
+	return fileDescriptor_89dab58ee42fbc88, []int{3}
+}
+func (m *Proof) XXX_Unmarshal(b []byte) error {
+	return m.Unmarshal(b)
+}
+func (m *Proof) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	b = b[:cap(b)]
+	n, err := m.MarshalTo(b)
+	if err != nil {
+		return nil, err
+	}
+	return b[:n], nil
+}
+func (m *Proof) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_Proof.Merge(m, src)
+}
+func (m *Proof) XXX_Size() int {
+	return m.Size()
+}
+func (m *Proof) XXX_DiscardUnknown() {
+	xxx_messageInfo_Proof.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_Proof proto.InternalMessageInfo
+
+func (m *Proof) GetBlocks() [][]byte {
+	if m != nil {
+		return m.Blocks
+	}
+	return nil
+}
+
 func init() {
 	proto.RegisterType((*KV)(nil), "hamt.KV")
 	proto.RegisterType((*Pointer)(nil), "hamt.Pointer")
 	proto.RegisterType((*Node)(nil), "hamt.Node")
+	proto.RegisterType((*Proof)(nil), "hamt.Proof")
 }
 
 func init() { proto.RegisterFile("hamt.proto", fileDescriptor_89dab58ee42fbc88) }
 
 var fileDescriptor_89dab58ee42fbc88 = []byte{
-	// 304 bytes of a gzipped FileDescriptorProto
-	0x1f, 0x8b, 0x08, 0x00, 0x00, 0x00, 0x00, 0x00, 0x02, 0xff, 0x4c, 0x90, 0xcf, 0x4a, 0xf3, 0x40,
-	0x14, 0xc5, 0x67, 0xda, 0x7e, 0x9f, 0xe9, 0x58, 0x41, 0x06, 0x17, 0xa5, 0xc2, 0xa5, 0x74, 0x15,
-	0xc1, 0x26, 0xa0, 0x6f, 0x90, 0x6d, 0x51, 0x24, 0x8b, 0x2e, 0xdc, 0x48, 0xa7, 0x49, 0xd3, 0x21,
-	0x7f, 0x26, 0x26, 0x93, 0x82, 0x3b, 0x1f, 0xc1, 0xc7, 0xf0, 0x51, 0x5c, 0x66, 0x99, 0xa5, 0x99,
-	0x6c, 0x5c, 0xf6, 0x11, 0x24, 0x13, 0x23, 0xee, 0xce, 0xef, 0x5e, 0xce, 0x3d, 0x97, 0x43, 0xc8,
-	0x7e, 0x13, 0x4b, 0x2b, 0xcd, 0x84, 0x14, 0x74, 0xd4, 0xea, 0xd9, 0x32, 0xe0, 0x72, 0x5f, 0x30,
-	0x6b, 0x2b, 0x62, 0x3b, 0x10, 0x81, 0xb0, 0xf5, 0x92, 0x15, 0x3b, 0x4d, 0x1a, 0xb4, 0xea, 0x4c,
-	0x8b, 0x6b, 0x32, 0x58, 0xad, 0xe9, 0x39, 0x19, 0x86, 0xfe, 0xcb, 0x14, 0xcf, 0xb1, 0x39, 0x76,
-	0x5b, 0x49, 0x2f, 0xc8, 0xbf, 0xc3, 0x26, 0x2a, 0xfc, 0xe9, 0x60, 0x8e, 0xcd, 0x89, 0xdb, 0xc1,
-	0xc2, 0x21, 0x27, 0x0f, 0x82, 0x27, 0xd2, 0xcf, 0xe8, 0x25, 0x19, 0x47, 0x3c, 0x09, 0x9f, 0x18,
-	0x97, 0xb9, 0x36, 0x4e, 0x5c, 0xa3, 0x1d, 0x38, 0x5c, 0xe6, 0x74, 0x46, 0x86, 0xe1, 0x21, 0x9f,
-	0x0e, 0xe6, 0x43, 0xf3, 0xf4, 0xc6, 0xb0, 0xf4, 0x93, 0xab, 0xb5, 0xdb, 0x0e, 0x17, 0x77, 0x64,
-	0x74, 0x2f, 0x3c, 0x9f, 0xce, 0x88, 0xc1, 0xb8, 0xdc, 0x71, 0x3f, 0xf2, 0x7a, 0x7f, 0xcf, 0xf4,
-	0x8a, 0x18, 0x69, 0x97, 0xd3, 0x1f, 0x39, 0xeb, 0x8e, 0xfc, 0xa4, 0xbb, 0xbf, 0x6b, 0x87, 0x95,
-	0x35, 0xa0, 0xaa, 0x06, 0x74, 0xac, 0x01, 0xbf, 0x2a, 0xc0, 0xef, 0x0a, 0xf0, 0x87, 0x02, 0x5c,
-	0x2a, 0xc0, 0x95, 0x02, 0xfc, 0xa9, 0x00, 0x7f, 0x29, 0x40, 0x47, 0x05, 0xf8, 0xad, 0x01, 0x54,
-	0x36, 0x80, 0xaa, 0x06, 0xd0, 0xa3, 0xf9, 0xa7, 0xa9, 0xe7, 0x42, 0x64, 0x45, 0xbc, 0x15, 0x89,
-	0xcc, 0x44, 0x64, 0x07, 0x62, 0xd9, 0xa6, 0x2d, 0x79, 0x1a, 0x79, 0x76, 0xca, 0xd8, 0x7f, 0xdd,
-	0xd5, 0xed, 0x77, 0x00, 0x00, 0x00, 0xff, 0xff, 0xa5, 0x45, 0x35, 0x14, 0x6e, 0x01, 0x00, 0x00,
+	// 376 bytes of a gzipped FileDescriptorProto
+	0x1f, 0x8b, 0x08, 0x00, 0x00, 0x00, 0x00, 0x00, 0x02, 0xff, 0x4c, 0x91, 0xc1, 0x6e, 0xda, 0x40,
+	0x10, 0x86, 0xbd, 0xd8, 0x50, 0xb3, 0x05, 0xa9, 0x5a, 0x55, 0x95, 0x45, 0xd5, 0xad, 0xe5, 0x93,
+	0x7b, 0x00, 0x4b, 0xed, 0x1b, 0x70, 0xa5, 0xaa, 0x90, 0x0f, 0x54, 0xca, 0x05, 0xb1, 0xb6, 0x31,
+	0x2b, 0x1b, 0x8f, 0x63, 0xd6, 0x44, 0xb9, 0xf1, 0x08, 0x79, 0x8c, 0x3c, 0x4a, 0x8e, 0x1c, 0x39,
+	0x86, 0xe5, 0x92, 0x23, 0x8f, 0x10, 0xed, 0x1a, 0x92, 0xdc, 0xe6, 0xfb, 0x67, 0xe7, 0x9f, 0xfd,
+	0x35, 0x18, 0xaf, 0x16, 0x6b, 0x31, 0x2a, 0x2b, 0x10, 0x40, 0x2c, 0x55, 0x0f, 0x86, 0x29, 0x17,
+	0xab, 0x9a, 0x8d, 0x22, 0x58, 0x07, 0x29, 0xa4, 0x10, 0xe8, 0x26, 0xab, 0x97, 0x9a, 0x34, 0xe8,
+	0xaa, 0x19, 0xf2, 0x16, 0xb8, 0x35, 0x99, 0x91, 0x2f, 0xd8, 0xcc, 0x92, 0x7b, 0x07, 0xb9, 0xc8,
+	0xef, 0x86, 0xaa, 0x24, 0x5f, 0x71, 0x7b, 0xbb, 0xc8, 0xeb, 0xc4, 0x69, 0xb9, 0xc8, 0xef, 0x85,
+	0x0d, 0x28, 0x35, 0x82, 0x38, 0x89, 0x1c, 0xd3, 0x45, 0xbe, 0x15, 0x36, 0x40, 0x7e, 0x60, 0xac,
+	0xdb, 0xf3, 0x9c, 0x17, 0x99, 0x63, 0xe9, 0x81, 0xae, 0x56, 0xfe, 0xf2, 0x22, 0xf3, 0xc6, 0xf8,
+	0xd3, 0x14, 0x78, 0x21, 0x92, 0x8a, 0x7c, 0xc7, 0x5d, 0xf5, 0x66, 0xce, 0xb8, 0xd8, 0xe8, 0x6d,
+	0xbd, 0xd0, 0x56, 0xc2, 0x98, 0x8b, 0x0d, 0x19, 0x60, 0x33, 0xdb, 0x6e, 0x9c, 0x96, 0x6b, 0xfa,
+	0x9f, 0x7f, 0xdb, 0x23, 0x9d, 0x6c, 0x32, 0x0b, 0x95, 0xe8, 0xed, 0x10, 0xb6, 0xfe, 0x41, 0x9c,
+	0x90, 0x01, 0xb6, 0x19, 0x17, 0x4b, 0x9e, 0xe4, 0xf1, 0xd5, 0xe0, 0xca, 0xe4, 0x17, 0xb6, 0xcb,
+	0x66, 0xd1, 0xd5, 0xa5, 0xdf, 0xb8, 0x5c, 0xd6, 0x87, 0x6f, 0xed, 0x26, 0x48, 0x5d, 0x88, 0xf7,
+	0x20, 0x75, 0x21, 0xd4, 0xf7, 0x18, 0x17, 0xf3, 0x3b, 0x1e, 0x8b, 0x95, 0xce, 0xd1, 0xd7, 0xee,
+	0xff, 0x15, 0x7b, 0x3f, 0x71, 0x7b, 0x5a, 0x01, 0x2c, 0xc9, 0x37, 0xdc, 0x61, 0x39, 0x44, 0x99,
+	0x4a, 0x60, 0xfa, 0xbd, 0xf0, 0x42, 0x63, 0xb6, 0x3f, 0x52, 0xe3, 0x70, 0xa4, 0xc6, 0xf9, 0x48,
+	0xd1, 0x4e, 0x52, 0xf4, 0x28, 0x29, 0x7a, 0x92, 0x14, 0xed, 0x25, 0x45, 0x07, 0x49, 0xd1, 0xb3,
+	0xa4, 0xe8, 0x45, 0x52, 0xe3, 0x2c, 0x29, 0x7a, 0x38, 0x51, 0x63, 0x7f, 0xa2, 0xc6, 0xe1, 0x44,
+	0x8d, 0x1b, 0xff, 0xc3, 0xcd, 0x6e, 0x6b, 0xa8, 0xea, 0x75, 0x04, 0x85, 0xa8, 0x20, 0x0f, 0x52,
+	0x18, 0xaa, 0x04, 0x43, 0x5e, 0xe6, 0x71, 0x50, 0x32, 0xd6, 0xd1, 0x57, 0xfb, 0xf3, 0x3a, 0x00,
+	0x3d, 0xba, 0x54, 0x92, 0xf8, 0x01, 0x00, 0x00,
 }
 
 func (this *KV) Equal(that interface{}) bool {
@@ -223,6 +300,12 @@ func (this *KV) Equal(that interface{}) bool {
 	if !bytes.Equal(this.Value, that1.Value) {
 		return false
 	}
+	if this.Codec != that1.Codec {
+		return false
+	}
+	if !bytes.Equal(this.ValueLink, that1.ValueLink) {
+		return false
+	}
 	return true
 }
 func (this *Pointer) Equal(that interface{}) bool {
@@ -287,16 +370,53 @@ func (this *Node) Equal(that interface{}) bool {
 			return false
 		}
 	}
+	if this.Count != that1.Count {
+		return false
+	}
+	if this.BitWidth != that1.BitWidth {
+		return false
+	}
+	return true
+}
+func (this *Proof) Equal(that interface{}) bool {
+	if that == nil {
+		return this == nil
+	}
+
+	that1, ok := that.(*Proof)
+	if !ok {
+		that2, ok := that.(Proof)
+		if ok {
+			that1 = &that2
+		} else {
+			return false
+		}
+	}
+	if that1 == nil {
+		return this == nil
+	} else if this == nil {
+		return false
+	}
+	if len(this.Blocks) != len(that1.Blocks) {
+		return false
+	}
+	for i := range this.Blocks {
+		if !bytes.Equal(this.Blocks[i], that1.Blocks[i]) {
+			return false
+		}
+	}
 	return true
 }
 func (this *KV) GoString() string {
 	if this == nil {
 		return "nil"
 	}
-	s := make([]string, 0, 6)
+	s := make([]string, 0, 8)
 	s = append(s, "&pb.KV{")
 	s = append(s, "Key: "+fmt.Sprintf("%#v", this.Key)+",\n")
 	s = append(s, "Value: "+fmt.Sprintf("%#v", this.Value)+",\n")
+	s = append(s, "Codec: "+fmt.Sprintf("%#v", this.Codec)+",\n")
+	s = append(s, "ValueLink: "+fmt.Sprintf("%#v", this.ValueLink)+",\n")
 	s = append(s, "}")
 	return strings.Join(s, "")
 }
@@ -317,12 +437,24 @@ func (this *Node) GoString() string {
 	if this == nil {
 		return "nil"
 	}
-	s := make([]string, 0, 6)
+	s := make([]string, 0, 8)
 	s = append(s, "&pb.Node{")
 	s = append(s, "Bitfield: "+fmt.Sprintf("%#v", this.Bitfield)+",\n")
 	if this.Pointers != nil {
 		s = append(s, "Pointers: "+fmt.Sprintf("%#v", this.Pointers)+",\n")
 	}
+	s = append(s, "Count: "+fmt.Sprintf("%#v", this.Count)+",\n")
+	s = append(s, "BitWidth: "+fmt.Sprintf("%#v", this.BitWidth)+",\n")
+	s = append(s, "}")
+	return strings.Join(s, "")
+}
+func (this *Proof) GoString() string {
+	if this == nil {
+		return "nil"
+	}
+	s := make([]string, 0, 5)
+	s = append(s, "&pb.Proof{")
+	s = append(s, "Blocks: "+fmt.Sprintf("%#v", this.Blocks)+",\n")
 	s = append(s, "}")
 	return strings.Join(s, "")
 }
@@ -361,6 +493,17 @@ func (m *KV) MarshalTo(dAtA []byte) (int, error) {
 		i = encodeVarintHamt(dAtA, i, uint64(len(m.Value)))
 		i += copy(dAtA[i:], m.Value)
 	}
+	if m.Codec != 0 {
+		dAtA[i] = 0x18
+		i++
+		i = encodeVarintHamt(dAtA, i, uint64(m.Codec))
+	}
+	if len(m.ValueLink) > 0 {
+		dAtA[i] = 0x22
+		i++
+		i = encodeVarintHamt(dAtA, i, uint64(len(m.ValueLink)))
+		i += copy(dAtA[i:], m.ValueLink)
+	}
 	return i, nil
 }
 
@@ -433,6 +576,42 @@ func (m *Node) MarshalTo(dAtA []byte) (int, error) {
 			i += n
 		}
 	}
+	if m.Count != 0 {
+		dAtA[i] = 0x18
+		i++
+		i = encodeVarintHamt(dAtA, i, uint64(m.Count))
+	}
+	if m.BitWidth != 0 {
+		dAtA[i] = 0x20
+		i++
+		i = encodeVarintHamt(dAtA, i, uint64(m.BitWidth))
+	}
+	return i, nil
+}
+
+func (m *Proof) Marshal() (dAtA []byte, err error) {
+	size := m.Size()
+	dAtA = make([]byte, size)
+	n, err := m.MarshalTo(dAtA)
+	if err != nil {
+		return nil, err
+	}
+	return dAtA[:n], nil
+}
+
+func (m *Proof) MarshalTo(dAtA []byte) (int, error) {
+	var i int
+	_ = i
+	var l int
+	_ = l
+	if len(m.Blocks) > 0 {
+		for _, b := range m.Blocks {
+			dAtA[i] = 0xa
+			i++
+			i = encodeVarintHamt(dAtA, i, uint64(len(b)))
+			i += copy(dAtA[i:], b)
+		}
+	}
 	return i, nil
 }
 
@@ -459,6 +638,13 @@ func (m *KV) Size() (n int) {
 	if l > 0 {
 		n += 1 + l + sovHamt(uint64(l))
 	}
+	if m.Codec != 0 {
+		n += 1 + sovHamt(uint64(m.Codec))
+	}
+	l = len(m.ValueLink)
+	if l > 0 {
+		n += 1 + l + sovHamt(uint64(l))
+	}
 	return n
 }
 
@@ -497,6 +683,27 @@ func (m *Node) Size() (n int) {
 			n += 1 + l + sovHamt(uint64(l))
 		}
 	}
+	if m.Count != 0 {
+		n += 1 + sovHamt(uint64(m.Count))
+	}
+	if m.BitWidth != 0 {
+		n += 1 + sovHamt(uint64(m.BitWidth))
+	}
+	return n
+}
+
+func (m *Proof) Size() (n int) {
+	if m == nil {
+		return 0
+	}
+	var l int
+	_ = l
+	if len(m.Blocks) > 0 {
+		for _, b := range m.Blocks {
+			l = len(b)
+			n += 1 + l + sovHamt(uint64(l))
+		}
+	}
 	return n
 }
 
@@ -513,6 +720,8 @@ func (this *KV) String() string {
 	s := strings.Join([]string{`&KV{`,
 		`Key:` + fmt.Sprintf("%v", this.Key) + `,`,
 		`Value:` + fmt.Sprintf("%v", this.Value) + `,`,
+		`Codec:` + fmt.Sprintf("%v", this.Codec) + `,`,
+		`ValueLink:` + fmt.Sprintf("%v", this.ValueLink) + `,`,
 		`}`,
 	}, "")
 	return s
@@ -545,6 +754,18 @@ func (this *Node) String() string {
 	s := strings.Join([]string{`&Node{`,
 		`Bitfield:` + fmt.Sprintf("%v", this.Bitfield) + `,`,
 		`Pointers:` + repeatedStringForPointers + `,`,
+		`Count:` + fmt.Sprintf("%v", this.Count) + `,`,
+		`BitWidth:` + fmt.Sprintf("%v", this.BitWidth) + `,`,
+		`}`,
+	}, "")
+	return s
+}
+func (this *Proof) String() string {
+	if this == nil {
+		return "nil"
+	}
+	s := strings.Join([]string{`&Proof{`,
+		`Blocks:` + fmt.Sprintf("%v", this.Blocks) + `,`,
 		`}`,
 	}, "")
 	return s
@@ -652,6 +873,59 @@ func (m *KV) Unmarshal(dAtA []byte) error {
 				m.Value = []byte{}
 			}
 			iNdEx = postIndex
+		case 3:
+			if wireType != 0 {
+				return fmt.Errorf("proto: wrong wireType = %d for field Codec", wireType)
+			}
+			m.Codec = 0
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowHamt
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				m.Codec |= uint64(b&0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+		case 4:
+			if wireType != 2 {
+				return fmt.Errorf("proto: wrong wireType = %d for field ValueLink", wireType)
+			}
+			var byteLen int
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowHamt
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				byteLen |= int(b&0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+			if byteLen < 0 {
+				return ErrInvalidLengthHamt
+			}
+			postIndex := iNdEx + byteLen
+			if postIndex < 0 {
+				return ErrInvalidLengthHamt
+			}
+			if postIndex > l {
+				return io.ErrUnexpectedEOF
+			}
+			m.ValueLink = append(m.ValueLink[:0], dAtA[iNdEx:postIndex]...)
+			if m.ValueLink == nil {
+				m.ValueLink = []byte{}
+			}
+			iNdEx = postIndex
 		default:
 			iNdEx = preIndex
 			skippy, err := skipHamt(dAtA[iNdEx:])
@@ -894,6 +1168,129 @@ func (m *Node) Unmarshal(dAtA []byte) error {
 				return err
 			}
 			iNdEx = postIndex
+		case 3:
+			if wireType != 0 {
+				return fmt.Errorf("proto: wrong wireType = %d for field Count", wireType)
+			}
+			m.Count = 0
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowHamt
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				m.Count |= uint64(b&0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+		case 4:
+			if wireType != 0 {
+				return fmt.Errorf("proto: wrong wireType = %d for field BitWidth", wireType)
+			}
+			m.BitWidth = 0
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowHamt
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				m.BitWidth |= uint32(b&0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+		default:
+			iNdEx = preIndex
+			skippy, err := skipHamt(dAtA[iNdEx:])
+			if err != nil {
+				return err
+			}
+			if skippy < 0 {
+				return ErrInvalidLengthHamt
+			}
+			if (iNdEx + skippy) < 0 {
+				return ErrInvalidLengthHamt
+			}
+			if (iNdEx + skippy) > l {
+				return io.ErrUnexpectedEOF
+			}
+			iNdEx += skippy
+		}
+	}
+
+	if iNdEx > l {
+		return io.ErrUnexpectedEOF
+	}
+	return nil
+}
+func (m *Proof) Unmarshal(dAtA []byte) error {
+	l := len(dAtA)
+	iNdEx := 0
+	for iNdEx < l {
+		preIndex := iNdEx
+		var wire uint64
+		for shift := uint(0); ; shift += 7 {
+			if shift >= 64 {
+				return ErrIntOverflowHamt
+			}
+			if iNdEx >= l {
+				return io.ErrUnexpectedEOF
+			}
+			b := dAtA[iNdEx]
+			iNdEx++
+			wire |= uint64(b&0x7F) << shift
+			if b < 0x80 {
+				break
+			}
+		}
+		fieldNum := int32(wire >> 3)
+		wireType := int(wire & 0x7)
+		if wireType == 4 {
+			return fmt.Errorf("proto: Proof: wiretype end group for non-group")
+		}
+		if fieldNum <= 0 {
+			return fmt.Errorf("proto: Proof: illegal tag %d (wire type %d)", fieldNum, wire)
+		}
+		switch fieldNum {
+		case 1:
+			if wireType != 2 {
+				return fmt.Errorf("proto: wrong wireType = %d for field Blocks", wireType)
+			}
+			var byteLen int
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowHamt
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				byteLen |= int(b&0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+			if byteLen < 0 {
+				return ErrInvalidLengthHamt
+			}
+			postIndex := iNdEx + byteLen
+			if postIndex < 0 {
+				return ErrInvalidLengthHamt
+			}
+			if postIndex > l {
+				return io.ErrUnexpectedEOF
+			}
+			m.Blocks = append(m.Blocks, make([]byte, postIndex-iNdEx))
+			copy(m.Blocks[len(m.Blocks)-1], dAtA[iNdEx:postIndex])
+			iNdEx = postIndex
 		default:
 			iNdEx = preIndex
 			skippy, err := skipHamt(dAtA[iNdEx:])
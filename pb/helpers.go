@@ -23,9 +23,22 @@ func (p *Pointer) SetLink(c cid.Cid) {
 	p.LinkBits = c.Bytes()
 }
 
+// ValueCid returns the CID of the block holding the value, or cid.Undef if
+// the value is inline.
+func (kv *KV) ValueCid() cid.Cid {
+	if len(kv.ValueLink) == 0 {
+		return cid.Undef
+	}
+	c, err := cid.Cast(kv.ValueLink)
+	if err != nil {
+		return cid.Undef
+	}
+	return c
+}
+
 // Equals returns whether or not one KV is equal to another
 func (kv *KV) Equals(other *KV) bool {
-	if kv.Key == other.Key && bytes.Equal(kv.Value, other.Value) {
+	if kv.Key == other.Key && kv.Codec == other.Codec && bytes.Equal(kv.Value, other.Value) && bytes.Equal(kv.ValueLink, other.ValueLink) {
 		return true
 	}
 
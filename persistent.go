@@ -0,0 +1,75 @@
+package hamt
+
+import (
+	"context"
+
+	"github.com/quorumcontrol/go-hamt-ipld/pb"
+)
+
+// owner identifies the version of a HAMT that may modify a node in place.
+type owner struct {
+	// owners are compared by address, which isn't unique for zero sized
+	// allocations
+	_ byte
+}
+
+func newOwner() *owner {
+	return new(owner)
+}
+
+// cloneFor returns a copy of n owned by o. Children and buckets are shared
+// with n, which is fine as neither is ever modified in place by o: buckets
+// are replaced on write and children belong to another owner.
+func (n *Node) cloneFor(o *owner) *Node {
+	nn := n.shallowCopy()
+	nn.owner = o
+	return nn
+}
+
+// With returns a new version of the HAMT with k set to v, leaving n as it
+// was. Only the nodes on the path to k are copied, every other subtree is
+// shared between the two versions. Both versions remain safe to read and
+// write: a later write to either one copies any shared node it touches.
+func (n *Node) With(ctx context.Context, k string, v interface{}) (*Node, error) {
+	kv, err := n.encodeKV(ctx, k, v)
+	if err != nil {
+		return nil, err
+	}
+	return n.persistentModify(ctx, k, kv)
+}
+
+// Without returns a new version of the HAMT with k removed, leaving n as it
+// was, in the same way as With. It returns ErrNotFound if k isn't set.
+func (n *Node) Without(ctx context.Context, k string) (*Node, error) {
+	return n.persistentModify(ctx, k, nil)
+}
+
+// Snapshot returns a copy of the HAMT in constant time. Nothing below the
+// root is copied up front: nodes shared between n and the snapshot are
+// copied by whichever of the two writes to them first. A reader can keep
+// using the snapshot as a consistent view while n is being written to.
+func (n *Node) Snapshot() *Node {
+	return n.fork()
+}
+
+func (n *Node) persistentModify(ctx context.Context, k string, v *pb.KV) (*Node, error) {
+	nn := n.fork()
+	if err := nn.modify(ctx, &hashBits{b: nn.hashKey(k)}, k, v); err != nil {
+		return nil, err
+	}
+	return nn, nil
+}
+
+// fork returns a copy of the root n that shares all of its children. Both
+// n and the copy give up ownership of the children, so that whichever is
+// written to first copies the nodes it modifies.
+func (n *Node) fork() *Node {
+	n.opMu.Lock()
+	defer n.opMu.Unlock()
+
+	n.mu.Lock()
+	n.owner = newOwner()
+	n.mu.Unlock()
+
+	return n.cloneFor(newOwner())
+}
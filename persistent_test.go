@@ -0,0 +1,249 @@
+package hamt
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+)
+
+func findString(t *testing.T, n *Node, k string) (string, error) {
+	out, err := n.Find(context.Background(), k)
+	if err != nil {
+		return "", err
+	}
+	return out.(string), nil
+}
+
+func TestWithWithout(t *testing.T) {
+	ctx := context.Background()
+	cs := NewCborStore()
+
+	v1 := NewNode(cs, UseTreeBitWidth(4))
+	for i := 0; i < 500; i++ {
+		if err := v1.Set(ctx, fmt.Sprintf("key%d", i), "v1"); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	v2, err := v1.With(ctx, "key0", "v2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	v3, err := v2.Without(ctx, "key1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := v3.Without(ctx, "missing"); err != ErrNotFound {
+		t.Fatalf("expected ErrNotFound, got %v", err)
+	}
+
+	// writing to an old version in place must not leak into newer ones
+	if err := v1.Set(ctx, "key2", "changed"); err != nil {
+		t.Fatal(err)
+	}
+
+	expect := []struct {
+		n   *Node
+		k   string
+		val string
+		err error
+	}{
+		{v1, "key0", "v1", nil},
+		{v1, "key1", "v1", nil},
+		{v1, "key2", "changed", nil},
+		{v2, "key0", "v2", nil},
+		{v2, "key1", "v1", nil},
+		{v2, "key2", "v1", nil},
+		{v3, "key0", "v2", nil},
+		{v3, "key1", "", ErrNotFound},
+		{v3, "key2", "v1", nil},
+	}
+	for i, e := range expect {
+		val, err := findString(t, e.n, e.k)
+		if err != e.err || val != e.val {
+			t.Fatalf("case %d: expected (%q, %v), got (%q, %v)", i, e.val, e.err, val, err)
+		}
+	}
+
+	for i := 3; i < 500; i++ {
+		k := fmt.Sprintf("key%d", i)
+		for _, n := range []*Node{v1, v2, v3} {
+			if val, err := findString(t, n, k); err != nil || val != "v1" {
+				t.Fatalf("expected untouched key %s in every version, got (%q, %v)", k, val, err)
+			}
+		}
+	}
+
+	// every version must flush to the same tree as one built from scratch
+	expected := NewNode(cs, UseTreeBitWidth(4))
+	for i := 0; i < 500; i++ {
+		if i == 1 {
+			continue
+		}
+		if err := expected.Set(ctx, fmt.Sprintf("key%d", i), "v1"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := expected.Set(ctx, "key0", "v2"); err != nil {
+		t.Fatal(err)
+	}
+	if !nodesEqual(t, cs, v3, expected) {
+		t.Fatal("persistent version differs from an equivalent HAMT")
+	}
+}
+
+func TestWithSharesSubtrees(t *testing.T) {
+	ctx := context.Background()
+	cs := NewCborStore()
+
+	v1 := NewNode(cs, UseTreeBitWidth(3))
+	for i := 0; i < 500; i++ {
+		if err := v1.Set(ctx, fmt.Sprintf("key%d", i), i); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	v2, err := v1.With(ctx, "key0", "changed")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(v1.Pointers) != len(v2.Pointers) {
+		t.Fatal("expected the versions to have the same layout")
+	}
+
+	idx, err := (&hashBits{b: v1.hashKey("key0")}).Next(3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	changed := v1.indexForBitPos(idx)
+
+	shared := 0
+	for i := range v1.Pointers {
+		c1, c2 := v1.Pointers[i].cache, v2.Pointers[i].cache
+		if i == changed {
+			if c1 != nil && c1 == c2 {
+				t.Fatal("the path to the changed key should have been copied")
+			}
+			continue
+		}
+		if c1 != c2 {
+			t.Fatalf("untouched subtree %d should be shared", i)
+		}
+		if c1 != nil {
+			shared++
+		}
+	}
+	if shared == 0 {
+		t.Fatal("expected some shared subtrees")
+	}
+}
+
+func TestPersistentConcurrentReads(t *testing.T) {
+	ctx := context.Background()
+	cs := NewCborStore()
+
+	root := NewNode(cs)
+	for i := 0; i < 200; i++ {
+		if err := root.Set(ctx, fmt.Sprintf("key%d", i), "base"); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var wg sync.WaitGroup
+	for r := 0; r < 4; r++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < 200; i++ {
+				if val, err := findString(t, root, fmt.Sprintf("key%d", i)); err != nil || val != "base" {
+					t.Errorf("old root changed: (%q, %v)", val, err)
+					return
+				}
+			}
+		}()
+	}
+
+	cur := root
+	for i := 0; i < 200; i++ {
+		next, err := cur.With(ctx, fmt.Sprintf("key%d", i), "new")
+		if err != nil {
+			t.Fatal(err)
+		}
+		cur = next
+	}
+	wg.Wait()
+
+	for i := 0; i < 200; i++ {
+		if val, err := findString(t, cur, fmt.Sprintf("key%d", i)); err != nil || val != "new" {
+			t.Fatalf("expected new value, got (%q, %v)", val, err)
+		}
+	}
+}
+
+func TestSnapshot(t *testing.T) {
+	ctx := context.Background()
+	cs := NewCborStore()
+
+	n := NewNode(cs, UseTreeBitWidth(4))
+	for i := 0; i < 1000; i++ {
+		if err := n.Set(ctx, fmt.Sprintf("key%d", i), "before"); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	snap := n.Snapshot()
+	for i, p := range n.Pointers {
+		if p.cache != snap.Pointers[i].cache {
+			t.Fatal("taking a snapshot should not copy any children")
+		}
+	}
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for it := snap.Iterator(ctx); it.Next(); {
+			if val, err := findString(t, snap, it.Key()); err != nil || val != "before" {
+				t.Errorf("snapshot changed under the reader: (%q, %v)", val, err)
+				return
+			}
+		}
+	}()
+
+	for i := 0; i < 1000; i++ {
+		k := fmt.Sprintf("key%d", i)
+		if i%3 == 0 {
+			if err := n.Delete(ctx, k); err != nil {
+				t.Fatal(err)
+			}
+			continue
+		}
+		if err := n.Set(ctx, k, "after"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	wg.Wait()
+
+	if err := snap.Set(ctx, "extra", "snap"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := n.Find(ctx, "extra"); err != ErrNotFound {
+		t.Fatalf("write to the snapshot leaked into the original: %v", err)
+	}
+
+	for i := 0; i < 1000; i++ {
+		k := fmt.Sprintf("key%d", i)
+		if val, err := findString(t, snap, k); err != nil || val != "before" {
+			t.Fatalf("expected the snapshot to keep %s, got (%q, %v)", k, val, err)
+		}
+		val, err := findString(t, n, k)
+		if i%3 == 0 {
+			if err != ErrNotFound {
+				t.Fatalf("expected %s to be deleted, got %v", k, err)
+			}
+		} else if err != nil || val != "after" {
+			t.Fatalf("expected the new value of %s, got (%q, %v)", k, val, err)
+		}
+	}
+}
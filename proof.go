@@ -0,0 +1,213 @@
+package hamt
+
+import (
+	"context"
+	"fmt"
+	"sort"
+
+	cid "github.com/ipfs/go-cid"
+	"github.com/quorumcontrol/go-hamt-ipld/goipldpb"
+	"github.com/quorumcontrol/go-hamt-ipld/pb"
+)
+
+// ErrInvalidProof is returned when a proof doesn't lead from its root to the
+// key it is checked for.
+var ErrInvalidProof = fmt.Errorf("invalid proof")
+
+// Prove returns a proof that k is set to its current value, or that it
+// isn't set: the blocks of the nodes along the hash path of k, from the root
+// down to the node holding the bucket of k or the empty slot where it would
+// be. The proof is checked against the CID of the root by VerifyProof, so
+// changes made since the last Flush must be flushed first.
+func (n *Node) Prove(ctx context.Context, k string) (*pb.Proof, error) {
+	return n.ProveMany(ctx, []string{k})
+}
+
+// ProveMany returns a single proof for every key in keys, as Prove does for
+// one. The nodes the hash paths of the keys share are only included once,
+// every node before the nodes below it. It is checked by VerifyMultiProof.
+func (n *Node) ProveMany(ctx context.Context, keys []string) (*pb.Proof, error) {
+	hashes := make([][]byte, len(keys))
+	for i, k := range keys {
+		hashes[i] = n.hashKey(k)
+	}
+	proof := new(pb.Proof)
+	if err := n.shallowCopy().prove(ctx, hashes, 0, proof); err != nil {
+		return nil, err
+	}
+	return proof, nil
+}
+
+// prove adds the blocks of n, which is consumed bits deep, and of the nodes
+// below it along the paths of hashes to proof.
+func (n *Node) prove(ctx context.Context, hashes [][]byte, consumed int, proof *pb.Proof) error {
+	blk, err := goipldpb.WrapObject(n)
+	if err != nil {
+		return err
+	}
+	proof.Blocks = append(proof.Blocks, blk.RawData())
+
+	bitWidth := n.conf().bitWidth
+	groups := make(map[int][][]byte)
+	var order []int
+	for _, h := range hashes {
+		idx, err := (&hashBits{b: h, consumed: consumed}).Next(bitWidth)
+		if err != nil {
+			return err
+		}
+		if n.Bitfield.Bit(idx) == 0 {
+			continue
+		}
+		if _, ok := groups[idx]; !ok {
+			order = append(order, idx)
+		}
+		groups[idx] = append(groups[idx], h)
+	}
+	sort.Ints(order)
+
+	for _, idx := range order {
+		p := n.getChild(byte(n.indexForBitPos(idx)))
+		if p == nil {
+			return ErrMalformedNode
+		}
+		if !p.isShard() {
+			continue
+		}
+		chnd, err := p.peekChild(ctx, n)
+		if err != nil {
+			return err
+		}
+		if err := chnd.shallowCopy().prove(ctx, groups[idx], consumed+bitWidth, proof); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// VerifyProof checks proof, as made by Prove, for k against the HAMT stored
+// under root, using nothing but the blocks in the proof. It returns the pair
+// of k if the proof shows it is set, ErrNotFound if it shows k isn't set, and
+// ErrInvalidProof if it shows neither. Values stored outside of their node
+// are returned as links, the pair doesn't prove what they hold beyond their
+// CID.
+func VerifyProof(root cid.Cid, k string, proof *pb.Proof, opts ...Option) (*pb.KV, error) {
+	conf := newConfig(opts...)
+	get := func(depth int, c cid.Cid) (*Node, error) {
+		if depth >= len(proof.Blocks) {
+			return nil, ErrInvalidProof
+		}
+		return proofNode(conf, c, proof.Blocks[depth], depth)
+	}
+	kv, depth, err := lookupProof(conf, root, k, get)
+	if err != nil && err != ErrNotFound {
+		return nil, err
+	}
+	// a proof for a single key holds nothing but its path
+	if depth != len(proof.Blocks) {
+		return nil, ErrInvalidProof
+	}
+	return kv, err
+}
+
+// VerifyMultiProof checks proof, as made by ProveMany, for keys against the
+// HAMT stored under root, like VerifyProof does for a single key. It returns
+// the pair of every key, or nil for the keys the proof shows aren't set.
+// ErrInvalidProof is returned if the proof doesn't show either for any of
+// the keys. The nodes of the proof are expected to be stored under the same
+// kind of CID as root.
+func VerifyMultiProof(root cid.Cid, keys []string, proof *pb.Proof, opts ...Option) ([]*pb.KV, error) {
+	blocks := make(map[string][]byte, len(proof.Blocks))
+	for _, data := range proof.Blocks {
+		c, err := root.Prefix().Sum(data)
+		if err != nil {
+			return nil, err
+		}
+		blocks[c.KeyString()] = data
+	}
+
+	conf := newConfig(opts...)
+	nodes := make(map[string]*Node)
+	get := func(depth int, c cid.Cid) (*Node, error) {
+		if nd, ok := nodes[c.KeyString()]; ok {
+			// a node may be reached at more than one depth
+			return nd, conf.checkNode(c, nd, depth)
+		}
+		data, ok := blocks[c.KeyString()]
+		if !ok {
+			return nil, ErrInvalidProof
+		}
+		nd, err := proofNode(conf, c, data, depth)
+		if err != nil {
+			return nil, err
+		}
+		nodes[c.KeyString()] = nd
+		return nd, nil
+	}
+
+	kvs := make([]*pb.KV, len(keys))
+	for i, k := range keys {
+		kv, _, err := lookupProof(conf, root, k, get)
+		if err != nil && err != ErrNotFound {
+			return nil, err
+		}
+		kvs[i] = kv
+	}
+	return kvs, nil
+}
+
+// lookupProof follows the hash path of k down from root, through the nodes
+// get returns for every depth. It returns the pair of k, or ErrNotFound if
+// the path shows k isn't set, along with the number of nodes it visited.
+func lookupProof(conf *config, root cid.Cid, k string, get func(depth int, c cid.Cid) (*Node, error)) (*pb.KV, int, error) {
+	hv := &hashBits{b: conf.hasher.Hash(k)}
+	c := root
+	for depth := 0; ; depth++ {
+		nd, err := get(depth, c)
+		if err != nil {
+			return nil, depth, err
+		}
+
+		idx, err := hv.Next(conf.bitWidth)
+		if err != nil {
+			return nil, depth, ErrInvalidProof
+		}
+		if nd.Bitfield.Bit(idx) == 0 {
+			return nil, depth + 1, ErrNotFound
+		}
+		p := nd.getChild(byte(nd.indexForBitPos(idx)))
+		if p == nil {
+			return nil, depth, ErrInvalidProof
+		}
+		if p.isShard() {
+			c = p.Link()
+			continue
+		}
+
+		for _, kv := range p.Kvs {
+			if kv.Key == k {
+				return kv, depth + 1, nil
+			}
+		}
+		return nil, depth + 1, ErrNotFound
+	}
+}
+
+// proofNode decodes data, a block of a proof, as the node c, depth levels
+// below the root. Nodes over the decode limits of conf are reported as such,
+// anything else wrong with them as ErrInvalidProof.
+func proofNode(conf *config, c cid.Cid, data []byte, depth int) (*Node, error) {
+	sum, err := c.Prefix().Sum(data)
+	if err != nil || !sum.Equals(c) {
+		return nil, ErrInvalidProof
+	}
+	nd, err := decodeNode(c, data, conf, depth)
+	if err == ErrBitWidthMismatch {
+		return nil, err
+	}
+	switch err.(type) {
+	case nil, *LimitError, *MalformedNodeError:
+		return nd, err
+	default:
+		return nil, ErrInvalidProof
+	}
+}
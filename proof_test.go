@@ -0,0 +1,205 @@
+package hamt
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/quorumcontrol/go-hamt-ipld/pb"
+)
+
+func TestProve(t *testing.T) {
+	ctx := context.Background()
+	_, n, vals := buildFlushedHamt(t, 2000, UseTreeBitWidth(4))
+	root, err := n.store.Put(ctx, n)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	keys := sortedKeys(vals)
+	for i := 0; i < len(keys); i += 97 {
+		k := keys[i]
+		proof, err := n.Prove(ctx, k)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// round trip through the serialized form
+		data, err := proof.Marshal()
+		if err != nil {
+			t.Fatal(err)
+		}
+		decoded := new(pb.Proof)
+		if err := decoded.Unmarshal(data); err != nil {
+			t.Fatal(err)
+		}
+
+		kv, err := VerifyProof(root, k, decoded, UseTreeBitWidth(4))
+		if err != nil {
+			t.Fatalf("proof for %s did not verify: %v", k, err)
+		}
+		var out []byte
+		if err := n.decodeKV(kv, &out); err != nil || !bytes.Equal(out, vals[k]) {
+			t.Fatalf("expected the value of %s, got %x (%v)", k, out, err)
+		}
+
+		if _, err := VerifyProof(root, "other", decoded, UseTreeBitWidth(4)); err != ErrInvalidProof && err != ErrNotFound {
+			t.Fatalf("expected the proof not to hold for another key, got %v", err)
+		}
+	}
+}
+
+func TestProveAbsent(t *testing.T) {
+	ctx := context.Background()
+
+	// a sparse tree, where missing keys mostly land in empty slots, and a
+	// dense one, where they land in buckets without them
+	for _, count := range []int{3, 2000} {
+		_, n, _ := buildFlushedHamt(t, count, UseTreeBitWidth(3))
+		root, err := n.store.Put(ctx, n)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for i := 0; i < 20; i++ {
+			k := fmt.Sprintf("missing%d", i)
+			proof, err := n.Prove(ctx, k)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, err := VerifyProof(root, k, proof, UseTreeBitWidth(3)); err != ErrNotFound {
+				t.Fatalf("expected %s to be proven absent, got %v", k, err)
+			}
+		}
+	}
+}
+
+func TestVerifyProofTampered(t *testing.T) {
+	ctx := context.Background()
+	_, n, vals := buildFlushedHamt(t, 2000, UseTreeBitWidth(4))
+	root, err := n.store.Put(ctx, n)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	k := sortedKeys(vals)[0]
+	proof, err := n.Prove(ctx, k)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(proof.Blocks) < 2 {
+		t.Fatalf("expected a proof of several blocks, got %d", len(proof.Blocks))
+	}
+
+	truncated := &pb.Proof{Blocks: proof.Blocks[:len(proof.Blocks)-1]}
+	if _, err := VerifyProof(root, k, truncated, UseTreeBitWidth(4)); err != ErrInvalidProof {
+		t.Fatalf("expected ErrInvalidProof for a truncated proof, got %v", err)
+	}
+
+	extended := &pb.Proof{Blocks: append(append([][]byte(nil), proof.Blocks...), proof.Blocks[0])}
+	if _, err := VerifyProof(root, k, extended, UseTreeBitWidth(4)); err != ErrInvalidProof {
+		t.Fatalf("expected ErrInvalidProof for extra blocks, got %v", err)
+	}
+
+	last := append([]byte(nil), proof.Blocks[len(proof.Blocks)-1]...)
+	last[len(last)-1] ^= 0xff
+	tampered := &pb.Proof{Blocks: append(append([][]byte(nil), proof.Blocks[:len(proof.Blocks)-1]...), last)}
+	if _, err := VerifyProof(root, k, tampered, UseTreeBitWidth(4)); err != ErrInvalidProof {
+		t.Fatalf("expected ErrInvalidProof for a tampered block, got %v", err)
+	}
+
+	if _, err := VerifyProof(root, k, proof, UseTreeBitWidth(5)); err != ErrBitWidthMismatch {
+		t.Fatalf("expected ErrBitWidthMismatch for another bit width, got %v", err)
+	}
+	// the root records its bit width
+	if _, err := VerifyProof(root, k, proof); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestProveMany(t *testing.T) {
+	ctx := context.Background()
+	_, n, vals := buildFlushedHamt(t, 5000, UseTreeBitWidth(4))
+	root, err := n.store.Put(ctx, n)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	all := sortedKeys(vals)
+	var keys []string
+	for i := 0; i < 300; i++ {
+		keys = append(keys, all[i*7])
+		if i%10 == 0 {
+			keys = append(keys, fmt.Sprintf("missing%d", i))
+		}
+	}
+
+	proof, err := n.ProveMany(ctx, keys)
+	if err != nil {
+		t.Fatal(err)
+	}
+	kvs, err := VerifyMultiProof(root, keys, proof, UseTreeBitWidth(4))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, k := range keys {
+		if strings.HasPrefix(k, "missing") {
+			if kvs[i] != nil {
+				t.Fatalf("expected %s to be proven absent, got %v", k, kvs[i])
+			}
+			continue
+		}
+		var out []byte
+		if kvs[i] == nil || n.decodeKV(kvs[i], &out) != nil || !bytes.Equal(out, vals[k]) {
+			t.Fatalf("expected the value of %s, got %v", k, kvs[i])
+		}
+	}
+
+	multi, err := proof.Marshal()
+	if err != nil {
+		t.Fatal(err)
+	}
+	single := 0
+	for _, k := range keys {
+		p, err := n.Prove(ctx, k)
+		if err != nil {
+			t.Fatal(err)
+		}
+		single += p.Size()
+	}
+	if len(multi)*3 > single {
+		t.Fatalf("expected the multiproof to be much smaller than single proofs, got %d and %d bytes", len(multi), single)
+	}
+
+	// keys whose path isn't in the proof can't be verified
+	small, err := n.ProveMany(ctx, keys[:2])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := VerifyMultiProof(root, keys[:2], small, UseTreeBitWidth(4)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := VerifyMultiProof(root, keys, small, UseTreeBitWidth(4)); err != ErrInvalidProof {
+		t.Fatalf("expected ErrInvalidProof for keys outside the proof, got %v", err)
+	}
+
+	tampered := &pb.Proof{Blocks: append([][]byte(nil), proof.Blocks...)}
+	last := append([]byte(nil), tampered.Blocks[len(tampered.Blocks)-1]...)
+	last[len(last)-1] ^= 0xff
+	tampered.Blocks[len(tampered.Blocks)-1] = last
+	if _, err := VerifyMultiProof(root, keys, tampered, UseTreeBitWidth(4)); err != ErrInvalidProof {
+		t.Fatalf("expected ErrInvalidProof for a tampered block, got %v", err)
+	}
+}
+
+// sortedKeys returns the keys of vals in order.
+func sortedKeys(vals map[string][]byte) []string {
+	keys := make([]string, 0, len(vals))
+	for k := range vals {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,37 @@
+package hamt
+
+import (
+	"context"
+	"fmt"
+
+	cid "github.com/ipfs/go-cid"
+)
+
+// ErrCodecMismatch is returned when a value is read with a method for a
+// different encoding than it was stored with, such as FindRaw for a value
+// stored with Set.
+var ErrCodecMismatch = fmt.Errorf("value stored with a different codec")
+
+// SetRaw sets k to v, stored exactly as given rather than encoded with the
+// codec of the HAMT. It can only be read back with FindRaw, unless the HAMT
+// uses RawCodec.
+func (n *Node) SetRaw(ctx context.Context, k string, v []byte) error {
+	kv, err := n.newKV(ctx, k, v, cid.Raw)
+	if err != nil {
+		return err
+	}
+	return n.modify(ctx, &hashBits{b: n.hashKey(k)}, k, kv)
+}
+
+// FindRaw returns the value of k stored with SetRaw, or ErrCodecMismatch if
+// it was stored with another codec.
+func (n *Node) FindRaw(ctx context.Context, k string) ([]byte, error) {
+	kv, err := n.GetKV(ctx, k)
+	if err != nil {
+		return nil, err
+	}
+	if codecOf(kv) != cid.Raw {
+		return nil, ErrCodecMismatch
+	}
+	return kv.Value, nil
+}
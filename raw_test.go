@@ -0,0 +1,119 @@
+package hamt
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"testing"
+)
+
+func TestSetRawFindRaw(t *testing.T) {
+	ctx := context.Background()
+	cs := NewCborStore()
+
+	n := NewNode(cs, UseTreeBitWidth(4))
+	for i := 0; i < 300; i++ {
+		k := fmt.Sprintf("key%d", i)
+		if i%2 == 0 {
+			if err := n.SetRaw(ctx, k, []byte(k)); err != nil {
+				t.Fatal(err)
+			}
+		} else if err := n.Set(ctx, k, k); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := n.SetRaw(ctx, "empty", nil); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := n.Flush(ctx); err != nil {
+		t.Fatal(err)
+	}
+	c, err := cs.Put(ctx, n)
+	if err != nil {
+		t.Fatal(err)
+	}
+	loaded, err := LoadNode(ctx, cs, c, UseTreeBitWidth(4))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 300; i++ {
+		k := fmt.Sprintf("key%d", i)
+		if i%2 == 0 {
+			raw, err := loaded.FindRaw(ctx, k)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(raw, []byte(k)) {
+				t.Fatalf("expected raw value %q, got %q", k, raw)
+			}
+			kv, err := loaded.GetKV(ctx, k)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(kv.Value, []byte(k)) {
+				t.Fatal("raw values should be stored exactly as given")
+			}
+			if _, err := loaded.Find(ctx, k); err != ErrCodecMismatch {
+				t.Fatalf("expected ErrCodecMismatch finding a raw value, got %v", err)
+			}
+			var s string
+			if err := loaded.FindInto(ctx, k, &s); err != ErrCodecMismatch {
+				t.Fatalf("expected ErrCodecMismatch decoding a raw value, got %v", err)
+			}
+			continue
+		}
+
+		v, err := loaded.Find(ctx, k)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if v.(string) != k {
+			t.Fatalf("expected %s, got %v", k, v)
+		}
+		if _, err := loaded.FindRaw(ctx, k); err != ErrCodecMismatch {
+			t.Fatalf("expected ErrCodecMismatch for a CBOR value, got %v", err)
+		}
+	}
+
+	raw, err := loaded.FindRaw(ctx, "empty")
+	if err != nil || len(raw) != 0 {
+		t.Fatalf("expected an empty raw value, got (%q, %v)", raw, err)
+	}
+	if _, err := loaded.FindRaw(ctx, "missing"); err != ErrNotFound {
+		t.Fatalf("expected ErrNotFound, got %v", err)
+	}
+
+	// switching encodings replaces the value
+	if err := loaded.Set(ctx, "key0", "cbor"); err != nil {
+		t.Fatal(err)
+	}
+	if v, err := loaded.Find(ctx, "key0"); err != nil || v.(string) != "cbor" {
+		t.Fatalf("expected the CBOR value to replace the raw one, got (%v, %v)", v, err)
+	}
+}
+
+func TestRawValuesChangeTheRoot(t *testing.T) {
+	ctx := context.Background()
+	cs := NewCborStore()
+
+	// a raw value that happens to be valid CBOR must not be mistaken for the
+	// same value stored with Set
+	nd, err := WrapObject("value")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	a := NewNode(cs)
+	if err := a.Set(ctx, "k", "value"); err != nil {
+		t.Fatal(err)
+	}
+	b := NewNode(cs)
+	if err := b.SetRaw(ctx, "k", nd.RawData()); err != nil {
+		t.Fatal(err)
+	}
+	if nodesEqual(t, cs, a, b) {
+		t.Fatal("raw and CBOR values should be distinguishable")
+	}
+}
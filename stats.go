@@ -0,0 +1,210 @@
+package hamt
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+
+	"github.com/quorumcontrol/go-hamt-ipld/goipldpb"
+)
+
+// defaultStatsConcurrency is the number of nodes Stats loads at once when no
+// StatsConcurrency option is given.
+const defaultStatsConcurrency = 8
+
+// StatsOption configures Stats.
+type StatsOption func(*statsConfig)
+
+type statsConfig struct {
+	concurrency int
+}
+
+// StatsConcurrency sets the number of subtrees Stats walks at once. workers
+// must be at least 1.
+func StatsConcurrency(workers int) StatsOption {
+	if workers < 1 {
+		panic(fmt.Sprintf("invalid HAMT stats concurrency %d", workers))
+	}
+	return func(c *statsConfig) {
+		c.concurrency = workers
+	}
+}
+
+// Stats describes the shape of a HAMT.
+type Stats struct {
+	// Nodes is the number of nodes, the root included.
+	Nodes int
+	// Entries is the number of key/value pairs.
+	Entries int
+	// Depth holds the number of nodes at every depth, the root being at
+	// depth 0.
+	Depth []int
+	// BucketFill maps a number of pairs to the number of buckets holding
+	// that many. Buckets only hold more than the bucket size when their keys
+	// collide completely.
+	BucketFill map[int]int
+	// Occupancy holds the average fraction of bitfield positions in use in
+	// the nodes at every depth.
+	Occupancy []float64
+	// BlockSizes describes the size of the encoded nodes.
+	BlockSizes BlockSizes
+}
+
+// BlockSizes describes the sizes, in bytes, of a set of blocks.
+type BlockSizes struct {
+	Total int
+	P50   int
+	P90   int
+	P99   int
+	Max   int
+}
+
+// Stats walks the whole HAMT and reports on its shape. Subtrees are walked
+// concurrently, by as many workers as StatsConcurrency sets, and nodes
+// that aren't already cached are dropped once visited. Block sizes are those
+// of the nodes as they would be written by Flush; subshards that have never
+// been flushed are counted without the link to them, so they are slightly
+// off until then.
+//
+// Like ForEach, every node is read atomically, but writes made while the
+// walk is in progress may or may not be seen.
+func (n *Node) Stats(ctx context.Context, opts ...StatsOption) (*Stats, error) {
+	conf := &statsConfig{concurrency: defaultStatsConcurrency}
+	for _, o := range opts {
+		o(conf)
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	w := &statsWalker{
+		ctx:        ctx,
+		cancel:     cancel,
+		workers:    make(chan struct{}, conf.concurrency-1),
+		bucketFill: make(map[int]int),
+	}
+	w.walk(n.shallowCopy(), 0)
+	w.wg.Wait()
+	if w.err != nil {
+		return nil, w.err
+	}
+	return w.stats(n.conf().bitWidth), nil
+}
+
+type statsWalker struct {
+	ctx    context.Context
+	cancel context.CancelFunc
+	// workers holds a token for every goroutine walking a subtree besides
+	// the one Stats was called from
+	workers chan struct{}
+	wg      sync.WaitGroup
+
+	mu         sync.Mutex
+	err        error
+	entries    int
+	depth      []int
+	occupied   []int
+	bucketFill map[int]int
+	sizes      []int
+}
+
+// walk records nd, which is depth deep, and its subtree. Subtrees are handed
+// to a new goroutine if a worker is free, and walked in place if not.
+func (w *statsWalker) walk(nd *Node, depth int) {
+	if w.ctx.Err() != nil {
+		w.fail(w.ctx.Err())
+		return
+	}
+
+	blk, err := goipldpb.WrapObject(nd)
+	if err != nil {
+		w.fail(err)
+		return
+	}
+
+	w.mu.Lock()
+	for len(w.depth) <= depth {
+		w.depth = append(w.depth, 0)
+		w.occupied = append(w.occupied, 0)
+	}
+	w.depth[depth]++
+	w.occupied[depth] += len(nd.Pointers)
+	w.sizes = append(w.sizes, len(blk.RawData()))
+	for _, p := range nd.Pointers {
+		if !p.isShard() {
+			w.entries += len(p.Kvs)
+			w.bucketFill[len(p.Kvs)]++
+		}
+	}
+	w.mu.Unlock()
+
+	for _, p := range nd.Pointers {
+		if !p.isShard() {
+			continue
+		}
+		chnd, err := p.peekChild(w.ctx, nd)
+		if err != nil {
+			w.fail(err)
+			return
+		}
+		chnd = chnd.shallowCopy()
+
+		select {
+		case w.workers <- struct{}{}:
+			w.wg.Add(1)
+			go func() {
+				defer func() {
+					<-w.workers
+					w.wg.Done()
+				}()
+				w.walk(chnd, depth+1)
+			}()
+		default:
+			w.walk(chnd, depth+1)
+		}
+	}
+}
+
+func (w *statsWalker) fail(err error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.err == nil {
+		w.err = err
+		w.cancel()
+	}
+}
+
+func (w *statsWalker) stats(bitWidth int) *Stats {
+	st := &Stats{
+		Entries:    w.entries,
+		Depth:      w.depth,
+		BucketFill: w.bucketFill,
+		Occupancy:  make([]float64, len(w.depth)),
+		BlockSizes: blockSizes(w.sizes),
+	}
+	for depth, nodes := range w.depth {
+		st.Nodes += nodes
+		st.Occupancy[depth] = float64(w.occupied[depth]) / float64(nodes<<uint(bitWidth))
+	}
+	return st
+}
+
+// blockSizes summarizes sizes, using the nearest rank for percentiles.
+func blockSizes(sizes []int) BlockSizes {
+	var bs BlockSizes
+	if len(sizes) == 0 {
+		return bs
+	}
+
+	sort.Ints(sizes)
+	for _, s := range sizes {
+		bs.Total += s
+	}
+	rank := func(p int) int {
+		return sizes[(p*len(sizes)+99)/100-1]
+	}
+	bs.P50, bs.P90, bs.P99 = rank(50), rank(90), rank(99)
+	bs.Max = sizes[len(sizes)-1]
+	return bs
+}
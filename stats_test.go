@@ -0,0 +1,151 @@
+package hamt
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"testing"
+
+	cid "github.com/ipfs/go-cid"
+)
+
+// blockSizesFrom returns the size of every node reachable from c.
+func blockSizesFrom(t *testing.T, cs *CborIpldStore, c cid.Cid) []int {
+	ctx := context.Background()
+	blk, err := cs.Nodes.Get(ctx, c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	nd, err := LoadNode(ctx, cs, c)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sizes := []int{len(blk.RawData())}
+	for _, p := range nd.Pointers {
+		if p.isShard() {
+			sizes = append(sizes, blockSizesFrom(t, cs, p.Link())...)
+		}
+	}
+	return sizes
+}
+
+func TestStats(t *testing.T) {
+	ctx := context.Background()
+
+	cs := NewCborStore()
+	n := NewNode(cs, UseTreeBitWidth(4))
+	for i := 0; i < 3000; i++ {
+		if err := n.Set(ctx, fmt.Sprintf("key%d", i), i); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := n.Flush(ctx); err != nil {
+		t.Fatal(err)
+	}
+	c, err := cs.Put(ctx, n)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	st, err := n.Stats(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sizes := blockSizesFrom(t, cs, c)
+	if st.Nodes != len(sizes) {
+		t.Fatalf("expected %d nodes, got %d", len(sizes), st.Nodes)
+	}
+	if st.Entries != 3000 {
+		t.Fatalf("expected 3000 entries, got %d", st.Entries)
+	}
+	if st.Depth[0] != 1 || len(st.Depth) < 2 {
+		t.Fatalf("expected a single root above other nodes, got %v", st.Depth)
+	}
+	if st.Occupancy[0] != 1 {
+		t.Fatalf("expected a full root, got occupancy %f", st.Occupancy[0])
+	}
+	buckets := 0
+	for size, count := range st.BucketFill {
+		if size < 1 || size > defaultBucketSize {
+			t.Fatalf("unexpected bucket of %d pairs", size)
+		}
+		buckets += size * count
+	}
+	if buckets != st.Entries {
+		t.Fatalf("expected the buckets to hold %d pairs, got %d", st.Entries, buckets)
+	}
+
+	total, max := 0, 0
+	for _, s := range sizes {
+		total += s
+		if s > max {
+			max = s
+		}
+	}
+	bs := st.BlockSizes
+	if bs.Total != total || bs.Max != max {
+		t.Fatalf("expected %d bytes, at most %d per block, got %+v", total, max, bs)
+	}
+	if bs.P50 > bs.P90 || bs.P90 > bs.P99 || bs.P99 > bs.Max {
+		t.Fatalf("percentiles out of order: %+v", bs)
+	}
+
+	loaded, err := LoadNode(ctx, cs, c, UseTreeBitWidth(4))
+	if err != nil {
+		t.Fatal(err)
+	}
+	serial, err := loaded.Stats(ctx, StatsConcurrency(1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(st, serial) {
+		t.Fatalf("expected the same stats from a single worker, got %+v and %+v", st, serial)
+	}
+}
+
+func TestStatsEmpty(t *testing.T) {
+	st, err := NewNode(NewCborStore()).Stats(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if st.Nodes != 1 || st.Entries != 0 || st.Occupancy[0] != 0 || st.BlockSizes.Total == 0 {
+		t.Fatalf("unexpected stats for an empty HAMT: %+v", st)
+	}
+}
+
+func TestStatsMissingNode(t *testing.T) {
+	ctx := context.Background()
+
+	cs := NewCborStore()
+	n := NewNode(cs, UseTreeBitWidth(3))
+	for i := 0; i < 500; i++ {
+		if err := n.Set(ctx, fmt.Sprintf("key%d", i), i); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := n.Flush(ctx); err != nil {
+		t.Fatal(err)
+	}
+	c, err := cs.Put(ctx, n)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	empty := NewCborStore()
+	blk, err := cs.Nodes.Get(ctx, c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := empty.Nodes.Add(ctx, blk); err != nil {
+		t.Fatal(err)
+	}
+	loaded, err := LoadNode(ctx, empty, c, UseTreeBitWidth(3))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := loaded.Stats(ctx); err == nil {
+		t.Fatal("expected an error for a HAMT with missing nodes")
+	}
+}
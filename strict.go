@@ -0,0 +1,161 @@
+package hamt
+
+import (
+	"fmt"
+
+	cid "github.com/ipfs/go-cid"
+	"github.com/quorumcontrol/go-hamt-ipld/goipldpb"
+)
+
+// ErrMalformedNode is returned when a node doesn't have the shape every
+// node of a HAMT has, such as a bitfield that doesn't have a bit set for
+// every pointer.
+var ErrMalformedNode = fmt.Errorf("malformed HAMT node")
+
+// DecodeLimits bounds the nodes loaded from the store when strict decoding
+// is enabled with UseStrictDecoding. A zero field takes its default.
+type DecodeLimits struct {
+	// MaxBlockSize is the size of the largest block, no limit by default.
+	MaxBlockSize int
+	// MaxBitfieldBits is the length of the longest bitfield, and
+	// MaxPointers the number of pointers a node may hold. Both default to
+	// 2 to the power of the bit width.
+	MaxBitfieldBits int
+	MaxPointers     int
+	// MaxBucketSize is the number of pairs a bucket may hold, the bucket
+	// size by default. Unless it is set, the buckets of keys that collide
+	// completely, at the bottom of the tree, are not limited.
+	MaxBucketSize int
+	// MaxKeyLength and MaxValueLength limit the pairs, no limit by default.
+	// MaxValueLength and MaxBlockSize also apply to values stored outside
+	// of their node, as they are read back.
+	MaxKeyLength   int
+	MaxValueLength int
+	// MaxDepth is the number of levels of the tree, the root being the
+	// first. By default it is the number of levels the hash has bits for.
+	MaxDepth int
+}
+
+// UseStrictDecoding checks every node loaded from the store against limits
+// before it is used, and fails with a *LimitError or a *MalformedNodeError
+// instead. It is meant for HAMTs received from untrusted peers, where a
+// crafted block could otherwise cause large allocations or deep recursion.
+func UseStrictDecoding(limits DecodeLimits) Option {
+	return func(c *config) {
+		c.limits = &limits
+	}
+}
+
+// LimitError is returned by strict decoding for a node over one of its
+// DecodeLimits.
+type LimitError struct {
+	Cid cid.Cid
+	// Limit names the limit: "block size", "bitfield bits", "pointers",
+	// "bucket size", "key length", "value length" or "depth".
+	Limit string
+	Size  int
+	Max   int
+}
+
+func (e *LimitError) Error() string {
+	return fmt.Sprintf("HAMT node %s over %s limit: %d > %d", e.Cid, e.Limit, e.Size, e.Max)
+}
+
+// MalformedNodeError is returned by strict decoding for a node that doesn't
+// have the shape of a HAMT node. It wraps ErrMalformedNode.
+type MalformedNodeError struct {
+	Cid    cid.Cid
+	Reason string
+}
+
+func (e *MalformedNodeError) Error() string {
+	return fmt.Sprintf("malformed HAMT node %s: %s", e.Cid, e.Reason)
+}
+
+func (e *MalformedNodeError) Unwrap() error {
+	return ErrMalformedNode
+}
+
+// decodeNode decodes data as the node c, depth levels below the root,
+// checking it against the decode limits of conf if it has any.
+func decodeNode(c cid.Cid, data []byte, conf *config, depth int) (*Node, error) {
+	if l := conf.limits; l != nil && l.MaxBlockSize > 0 && len(data) > l.MaxBlockSize {
+		return nil, &LimitError{Cid: c, Limit: "block size", Size: len(data), Max: l.MaxBlockSize}
+	}
+
+	var out Node
+	if err := goipldpb.DecodeInto(data, &out); err != nil {
+		return nil, err
+	}
+	if depth == 0 {
+		if err := conf.useRootWidth(&out); err != nil {
+			return nil, err
+		}
+	}
+	if err := conf.checkNode(c, &out, depth); err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
+
+// checkNode checks nd, the node c, against the decode limits of the config.
+func (conf *config) checkNode(c cid.Cid, nd *Node, depth int) error {
+	l := conf.limits
+	if l == nil {
+		return nil
+	}
+	over := func(limit string, size, max int) error {
+		return &LimitError{Cid: c, Limit: limit, Size: size, Max: max}
+	}
+	withDefault := func(v, def int) int {
+		if v > 0 {
+			return v
+		}
+		return def
+	}
+
+	width := 1 << uint(conf.bitWidth)
+	levels := len(conf.hasher.Hash("")) * 8 / conf.bitWidth
+	if max := withDefault(l.MaxDepth, levels); depth >= max {
+		return over("depth", depth+1, max)
+	}
+	if max := withDefault(l.MaxBitfieldBits, width); nd.Bitfield.BitLen() > max {
+		return over("bitfield bits", nd.Bitfield.BitLen(), max)
+	}
+	if max := withDefault(l.MaxPointers, width); len(nd.Pointers) > max {
+		return over("pointers", len(nd.Pointers), max)
+	}
+	if set := popCount(nd.Bitfield); set != len(nd.Pointers) {
+		return &MalformedNodeError{Cid: c, Reason: fmt.Sprintf("%d bits set for %d pointers", set, len(nd.Pointers))}
+	}
+
+	maxBucket := withDefault(l.MaxBucketSize, conf.bucketSize)
+	// buckets of colliding keys only live where the hash runs out
+	collisions := l.MaxBucketSize == 0 && depth == levels-1
+	for i, p := range nd.Pointers {
+		switch {
+		case len(p.LinkBits) > 0 && len(p.Kvs) > 0:
+			return &MalformedNodeError{Cid: c, Reason: fmt.Sprintf("pointer %d has both a link and pairs", i)}
+		case len(p.LinkBits) > 0:
+			if _, err := cid.Cast(p.LinkBits); err != nil {
+				return &MalformedNodeError{Cid: c, Reason: fmt.Sprintf("pointer %d has an invalid link: %v", i, err)}
+			}
+			continue
+		case len(p.Kvs) == 0:
+			return &MalformedNodeError{Cid: c, Reason: fmt.Sprintf("pointer %d is empty", i)}
+		}
+
+		if len(p.Kvs) > maxBucket && !collisions {
+			return over("bucket size", len(p.Kvs), maxBucket)
+		}
+		for _, kv := range p.Kvs {
+			if l.MaxKeyLength > 0 && len(kv.Key) > l.MaxKeyLength {
+				return over("key length", len(kv.Key), l.MaxKeyLength)
+			}
+			if l.MaxValueLength > 0 && len(kv.Value) > l.MaxValueLength {
+				return over("value length", len(kv.Value), l.MaxValueLength)
+			}
+		}
+	}
+	return nil
+}
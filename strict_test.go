@@ -0,0 +1,233 @@
+package hamt
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+
+	cid "github.com/ipfs/go-cid"
+	"github.com/quorumcontrol/go-hamt-ipld/pb"
+)
+
+func TestStrictDecodingValidTrees(t *testing.T) {
+	ctx := context.Background()
+	constantHash := UseHasher(HasherFunc(func(string) []byte {
+		return []byte{0xab, 0xcd}
+	}))
+
+	for _, tc := range []struct {
+		count int
+		opts  []Option
+	}{
+		{1000, nil},
+		{1000, []Option{UseTreeBitWidth(3), UseBucketSize(1)}},
+		// keys that collide completely share a bucket at the bottom
+		{20, []Option{constantHash, UseTreeBitWidth(8)}},
+	} {
+		_, n, _ := buildFlushedHamt(t, tc.count, tc.opts...)
+		root, err := n.store.Put(ctx, n)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		strict := append(tc.opts, UseStrictDecoding(DecodeLimits{}))
+		loaded, err := LoadNode(ctx, n.store, root, strict...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		count := 0
+		if err := loaded.ForEach(ctx, func(*pb.KV) error {
+			count++
+			return nil
+		}); err != nil {
+			t.Fatal(err)
+		}
+		if count != tc.count {
+			t.Fatalf("expected %d pairs, got %d", tc.count, count)
+		}
+	}
+}
+
+func TestStrictDecodingLimits(t *testing.T) {
+	ctx := context.Background()
+	cs := NewCborStore()
+
+	put := func(nd *Node) cid.Cid {
+		c, err := cs.Put(ctx, nd)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return c
+	}
+	// node returns a node with the bits in slots set and a bucket for every
+	// one of kvs
+	node := func(slots []int, kvs ...[]*pb.KV) *Node {
+		nd := newNode(cs, defaultConf)
+		for _, s := range slots {
+			nd.Bitfield.SetBit(nd.Bitfield, s, 1)
+		}
+		for _, b := range kvs {
+			nd.Pointers = append(nd.Pointers, &Pointer{Pointer: &pb.Pointer{Kvs: b}})
+		}
+		return nd
+	}
+	kv := func(k string, size int) *pb.KV {
+		return &pb.KV{Key: k, Value: make([]byte, size)}
+	}
+	a := slotOf("a")
+
+	cases := []struct {
+		name   string
+		root   *Node
+		limits DecodeLimits
+		limit  string
+	}{
+		{"block size", node([]int{a}, []*pb.KV{kv("a", 100)}), DecodeLimits{MaxBlockSize: 50}, "block size"},
+		{"bitfield", node([]int{300}), DecodeLimits{}, "bitfield bits"},
+		{"pointers", node([]int{a}, []*pb.KV{kv("a", 1)}, []*pb.KV{kv("a", 1)}), DecodeLimits{MaxPointers: 1}, "pointers"},
+		{"bucket", node([]int{a}, []*pb.KV{kv("a", 1), kv("b", 1), kv("c", 1), kv("d", 1)}), DecodeLimits{}, "bucket size"},
+		{"key", node([]int{a}, []*pb.KV{kv(strings.Repeat("a", 20), 1)}), DecodeLimits{MaxKeyLength: 10}, "key length"},
+		{"value", node([]int{a}, []*pb.KV{kv("a", 20)}), DecodeLimits{MaxValueLength: 10}, "value length"},
+	}
+	for _, tc := range cases {
+		_, err := LoadNode(ctx, cs, put(tc.root), UseStrictDecoding(tc.limits))
+		lerr, ok := err.(*LimitError)
+		if !ok || lerr.Limit != tc.limit {
+			t.Fatalf("%s: expected a %s LimitError, got %v", tc.name, tc.limit, err)
+		}
+	}
+
+	malformed := []*Node{
+		node([]int{a, a + 1}, []*pb.KV{kv("a", 1)}),
+		node([]int{a}, nil),
+		node([]int{a}, []*pb.KV{}),
+	}
+	malformed[2].Pointers[0].LinkBits = []byte{0xff}
+	for i, nd := range malformed {
+		_, err := LoadNode(ctx, cs, put(nd), UseStrictDecoding(DecodeLimits{}))
+		if _, ok := err.(*MalformedNodeError); !ok || !errors.Is(err, ErrMalformedNode) {
+			t.Fatalf("case %d: expected a MalformedNodeError, got %v", i, err)
+		}
+	}
+}
+
+func TestStrictDecodingDepth(t *testing.T) {
+	ctx := context.Background()
+	_, n, vals := buildFlushedHamt(t, 1000, UseTreeBitWidth(3))
+	root, err := n.store.Put(ctx, n)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	loaded, err := LoadNode(ctx, n.store, root, UseTreeBitWidth(3), UseStrictDecoding(DecodeLimits{MaxDepth: 2}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = loaded.ForEach(ctx, func(*pb.KV) error { return nil })
+	if lerr, ok := err.(*LimitError); !ok || lerr.Limit != "depth" {
+		t.Fatalf("expected a depth LimitError, got %v", err)
+	}
+
+	var k string
+	for k = range vals {
+		break
+	}
+	proof, err := n.Prove(ctx, k)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(proof.Blocks) < 3 {
+		t.Fatalf("expected a proof deeper than the limit, got %d blocks", len(proof.Blocks))
+	}
+	_, err = VerifyProof(root, k, proof, UseTreeBitWidth(3), UseStrictDecoding(DecodeLimits{MaxDepth: 2}))
+	if lerr, ok := err.(*LimitError); !ok || lerr.Limit != "depth" {
+		t.Fatalf("expected a depth LimitError, got %v", err)
+	}
+}
+
+func TestMalformedNodeWithoutStrictDecoding(t *testing.T) {
+	ctx := context.Background()
+	cs := NewCborStore()
+
+	// a bit is set for every key, but there is only one pointer
+	nd := newNode(cs, defaultConf)
+	var keys []string
+	for i := 0; len(keys) < 2; i++ {
+		k := fmt.Sprintf("key%d", i)
+		if len(keys) == 0 || slotOf(k) > slotOf(keys[0]) {
+			keys = append(keys, k)
+			nd.Bitfield.SetBit(nd.Bitfield, slotOf(k), 1)
+		}
+	}
+	nd.Pointers = append(nd.Pointers, &Pointer{Pointer: &pb.Pointer{Kvs: []*pb.KV{{Key: keys[0], Value: []byte{0x01}}}}})
+	c, err := cs.Put(ctx, nd)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	loaded, err := LoadNode(ctx, cs, c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := loaded.Find(ctx, keys[1]); err != ErrMalformedNode {
+		t.Fatalf("expected ErrMalformedNode from Find, got %v", err)
+	}
+	if err := loaded.Set(ctx, keys[1], 1); err != ErrMalformedNode {
+		t.Fatalf("expected ErrMalformedNode from Set, got %v", err)
+	}
+	if _, err := loaded.ApplyBatch(ctx, []Op{{Key: keys[1], Value: 1}}); err != ErrMalformedNode {
+		t.Fatalf("expected ErrMalformedNode from ApplyBatch, got %v", err)
+	}
+}
+
+func TestStrictDecodingExternalValues(t *testing.T) {
+	ctx := context.Background()
+	cs := NewCborStore()
+	opts := []Option{UseValueThreshold(16), UseValueChunkSize(512)}
+
+	n := NewNode(cs, opts...)
+	values := map[string]int{"small": 150, "chunk": 400, "chunked": 3000}
+	for k, size := range values {
+		if err := n.SetRaw(ctx, k, make([]byte, size)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := n.Flush(ctx); err != nil {
+		t.Fatal(err)
+	}
+	root, err := cs.Put(ctx, n)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []struct {
+		limits DecodeLimits
+		key    string
+		limit  string
+	}{
+		{DecodeLimits{MaxValueLength: 200}, "small", ""},
+		{DecodeLimits{MaxValueLength: 200}, "chunk", "value length"},
+		{DecodeLimits{MaxValueLength: 1000}, "chunked", "value length"},
+		{DecodeLimits{MaxBlockSize: 300}, "small", ""},
+		{DecodeLimits{MaxBlockSize: 300}, "chunk", "block size"},
+		{DecodeLimits{MaxBlockSize: 300}, "chunked", "block size"},
+	}
+	for _, tc := range cases {
+		loaded, err := LoadNode(ctx, cs, root, append(opts, UseStrictDecoding(tc.limits))...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		v, err := loaded.FindRaw(ctx, tc.key)
+		if tc.limit == "" {
+			if err != nil || len(v) != values[tc.key] {
+				t.Fatalf("%s: expected %d bytes, got %d, %v", tc.key, values[tc.key], len(v), err)
+			}
+			continue
+		}
+		if lerr, ok := err.(*LimitError); !ok || lerr.Limit != tc.limit {
+			t.Fatalf("%s: expected a %s LimitError, got %v", tc.key, tc.limit, err)
+		}
+	}
+}
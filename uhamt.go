@@ -27,3 +27,19 @@ func popCount(i *big.Int) int {
 	}
 	return n
 }
+
+// bitPosForIndex is the inverse of indexForBitPos, returning the bit in the
+// bitset that corresponds to the given index within the collapsed array, or
+// -1 if there is no such index.
+func (n *Node) bitPosForIndex(i int) int {
+	for bp := 0; bp < n.Bitfield.BitLen(); bp++ {
+		if n.Bitfield.Bit(bp) == 0 {
+			continue
+		}
+		if i == 0 {
+			return bp
+		}
+		i--
+	}
+	return -1
+}
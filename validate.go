@@ -0,0 +1,242 @@
+package hamt
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+
+	cid "github.com/ipfs/go-cid"
+	"github.com/quorumcontrol/go-hamt-ipld/pb"
+)
+
+// ViolationKind identifies the invariant a Violation breaks.
+type ViolationKind int
+
+const (
+	// MissingBlock is a node that can't be loaded or decoded, or a value
+	// stored outside of its node that can't be read back.
+	MissingBlock ViolationKind = iota
+	// BitfieldMismatch is a node whose bitfield doesn't have one bit set
+	// for every pointer, or has bits set past the bit width.
+	BitfieldMismatch
+	// EmptyPointer is a pointer with neither a link nor pairs.
+	EmptyPointer
+	// LinkAndKvs is a pointer with both a link and pairs.
+	LinkAndKvs
+	// InvalidLink is a pointer whose link isn't a CID.
+	InvalidLink
+	// UnsortedBucket is a bucket whose keys aren't in ascending order.
+	UnsortedBucket
+	// DuplicateKey is a bucket holding a key more than once.
+	DuplicateKey
+	// OversizedBucket is a bucket over the bucket size whose keys don't
+	// collide completely, so it should have been split.
+	OversizedBucket
+	// MisplacedKey is a key stored where its hash doesn't lead.
+	MisplacedKey
+	// NonCanonicalShard is a subshard that holds few enough pairs to have
+	// been collapsed into a bucket of its parent.
+	NonCanonicalShard
+	// CountMismatch is a node whose count isn't the number of pairs below
+	// it.
+	CountMismatch
+)
+
+var violationKinds = map[ViolationKind]string{
+	MissingBlock:      "missing block",
+	BitfieldMismatch:  "bitfield mismatch",
+	EmptyPointer:      "empty pointer",
+	LinkAndKvs:        "pointer with link and pairs",
+	InvalidLink:       "invalid link",
+	UnsortedBucket:    "unsorted bucket",
+	DuplicateKey:      "duplicate key",
+	OversizedBucket:   "oversized bucket",
+	MisplacedKey:      "misplaced key",
+	NonCanonicalShard: "non-canonical shard",
+	CountMismatch:     "count mismatch",
+}
+
+func (k ViolationKind) String() string {
+	if s, ok := violationKinds[k]; ok {
+		return s
+	}
+	return fmt.Sprintf("ViolationKind(%d)", int(k))
+}
+
+// Violation is a broken invariant found by Validate.
+type Violation struct {
+	Kind ViolationKind
+	// Path holds the bit positions of the pointers leading from the root
+	// to the node the violation is in, or to its pointer.
+	Path []int
+	// Cid is the node the violation is in.
+	Cid    cid.Cid
+	Detail string
+}
+
+func (v Violation) String() string {
+	return fmt.Sprintf("%s at %v in %s: %s", v.Kind, v.Path, v.Cid, v.Detail)
+}
+
+// Validate loads every node of the HAMT stored under root in cs and checks
+// that the tree is in the canonical form the HAMT methods keep it in, and
+// that every value stored outside of its node can be read. Every violation
+// found is returned, a block that can't be loaded is reported as a
+// MissingBlock rather than ending the walk. An error is only returned if ctx
+// ends.
+func Validate(ctx context.Context, cs *CborIpldStore, root cid.Cid, opts ...Option) ([]Violation, error) {
+	v := &validator{ctx: ctx, store: cs, conf: newConfig(opts...)}
+	if _, err := v.node(root, nil); err != nil {
+		return nil, err
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return v.violations, nil
+}
+
+type validator struct {
+	ctx        context.Context
+	store      *CborIpldStore
+	conf       *config
+	violations []Violation
+}
+
+func (v *validator) report(kind ViolationKind, path []int, c cid.Cid, format string, args ...interface{}) {
+	v.violations = append(v.violations, Violation{
+		Kind:   kind,
+		Path:   append([]int(nil), path...),
+		Cid:    c,
+		Detail: fmt.Sprintf(format, args...),
+	})
+}
+
+// node checks the node c at path and everything below it. It returns the
+// node, or nil if it couldn't be loaded.
+func (v *validator) node(c cid.Cid, path []int) (*Node, error) {
+	if err := v.ctx.Err(); err != nil {
+		return nil, err
+	}
+	nd, err := loadNode(v.ctx, v.store, c, v.conf, len(path))
+	if err != nil {
+		v.report(MissingBlock, path, c, "%v", err)
+		return nil, nil
+	}
+
+	width := 1 << uint(v.conf.bitWidth)
+	if nd.Bitfield.BitLen() > width {
+		v.report(BitfieldMismatch, path, c, "bits set past position %d", width-1)
+	}
+	if set := popCount(nd.Bitfield); set != len(nd.Pointers) {
+		v.report(BitfieldMismatch, path, c, "%d bits set for %d pointers", set, len(nd.Pointers))
+	}
+
+	// the number of pairs below the node, as long as they could be counted
+	count := uint64(0)
+	counted := true
+	for i, p := range nd.Pointers {
+		idx := nd.bitPosForIndex(i)
+		ppath := append(path[:len(path):len(path)], idx)
+
+		switch {
+		case len(p.LinkBits) == 0 && len(p.Kvs) == 0:
+			v.report(EmptyPointer, ppath, c, "pointer %d", i)
+		case len(p.LinkBits) > 0 && !p.Link().Defined():
+			v.report(InvalidLink, ppath, c, "pointer %d", i)
+			counted = false
+		case p.Link().Defined():
+			if len(p.Kvs) > 0 {
+				v.report(LinkAndKvs, ppath, c, "pointer %d", i)
+			}
+			chnd, err := v.node(p.Link(), ppath)
+			if err != nil {
+				return nil, err
+			}
+			if chnd == nil || chnd.uncounted {
+				counted = false
+				continue
+			}
+			count += chnd.count
+			v.checkShard(chnd, p.Link(), ppath)
+		default:
+			v.bucket(p.Kvs, c, ppath)
+			count += uint64(len(p.Kvs))
+		}
+	}
+
+	// nodes stored before counts were recorded have nothing to check
+	if counted && !nd.uncounted && nd.count != count {
+		v.report(CountMismatch, path, c, "count is %d, holds %d pairs", nd.count, count)
+	}
+	return nd, nil
+}
+
+// checkShard checks that chnd, the subshard c at path, couldn't have been
+// collapsed into its parent.
+func (v *validator) checkShard(chnd *Node, c cid.Cid, path []int) {
+	pairs := 0
+	for _, p := range chnd.Pointers {
+		if p.isShard() || len(p.LinkBits) > 0 {
+			return
+		}
+		pairs += len(p.Kvs)
+	}
+	if pairs <= v.conf.bucketSize {
+		v.report(NonCanonicalShard, path, c, "holds only %d pairs", pairs)
+	}
+}
+
+// bucket checks kvs, the bucket at path in the node c.
+func (v *validator) bucket(kvs []*pb.KV, c cid.Cid, path []int) {
+	for i := 1; i < len(kvs); i++ {
+		switch {
+		case kvs[i].Key == kvs[i-1].Key:
+			v.report(DuplicateKey, path, c, "key %q", kvs[i].Key)
+		case kvs[i].Key < kvs[i-1].Key:
+			v.report(UnsortedBucket, path, c, "key %q after %q", kvs[i].Key, kvs[i-1].Key)
+		}
+	}
+
+	for _, kv := range kvs {
+		if len(kv.ValueLink) == 0 {
+			continue
+		}
+		if err := v.value(kv); err != nil && v.ctx.Err() == nil {
+			v.report(MissingBlock, path, c, "value of key %q: %v", kv.Key, err)
+		}
+	}
+
+	bitWidth := v.conf.bitWidth
+	for _, kv := range kvs {
+		hv := &hashBits{b: v.conf.hasher.Hash(kv.Key)}
+		for depth, idx := range path {
+			if got, err := hv.Next(bitWidth); err != nil || got != idx {
+				v.report(MisplacedKey, path, c, "key %q belongs under position %d at depth %d", kv.Key, got, depth)
+				break
+			}
+		}
+	}
+
+	if len(kvs) > v.conf.bucketSize {
+		hv := &hashBits{b: v.conf.hasher.Hash(kvs[0].Key), consumed: len(path) * bitWidth}
+		if hv.hasNext(bitWidth) {
+			v.report(OversizedBucket, path, c, "%d pairs", len(kvs))
+		}
+	}
+}
+
+// value reads the value kv links to, to check that all of its blocks are
+// there.
+func (v *validator) value(kv *pb.KV) error {
+	c, err := cid.Cast(kv.ValueLink)
+	if err != nil {
+		return err
+	}
+	r, err := v.store.valueReader(v.ctx, c, v.conf.limits)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(ioutil.Discard, r)
+	return err
+}
@@ -0,0 +1,215 @@
+package hamt
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+
+	cid "github.com/ipfs/go-cid"
+	format "github.com/ipfs/go-ipld-format"
+	"github.com/ipfs/go-merkledag"
+	"github.com/quorumcontrol/go-hamt-ipld/pb"
+)
+
+func TestValidateValidTrees(t *testing.T) {
+	ctx := context.Background()
+
+	for _, opts := range [][]Option{
+		nil,
+		{UseTreeBitWidth(3), UseBucketSize(1)},
+		{UseHasher(shortIdentityHash)},
+	} {
+		_, n, vals := buildFlushedHamt(t, 2000, opts...)
+		root, err := n.store.Put(ctx, n)
+		if err != nil {
+			t.Fatal(err)
+		}
+		// deletions collapse shards, which must leave the tree canonical
+		i := 0
+		for k := range vals {
+			if i%3 == 0 {
+				if err := n.Delete(ctx, k); err != nil {
+					t.Fatal(err)
+				}
+			}
+			i++
+		}
+		if err := n.Flush(ctx); err != nil {
+			t.Fatal(err)
+		}
+		after, err := n.store.Put(ctx, n)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		for _, c := range []cid.Cid{root, after} {
+			violations, err := Validate(ctx, n.store, c, opts...)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(violations) != 0 {
+				t.Fatalf("expected no violations, got %v", violations)
+			}
+		}
+	}
+}
+
+// slotOf returns the position k hashes to at the first level.
+func slotOf(k string) int {
+	idx, _ := (&hashBits{b: defaultConf.hasher.Hash(k)}).Next(defaultBitWidth)
+	return idx
+}
+
+func TestValidateViolations(t *testing.T) {
+	ctx := context.Background()
+	cs := NewCborStore()
+
+	kv := func(k string) *pb.KV {
+		return &pb.KV{Key: k, Value: []byte{0x01}}
+	}
+	put := func(nd *Node) cid.Cid {
+		c, err := cs.Put(ctx, nd)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return c
+	}
+	// node returns a node holding ps at the positions in slots
+	node := func(count uint64, slots []int, ps ...*pb.Pointer) *Node {
+		nd := newNode(cs, defaultConf)
+		for _, s := range slots {
+			nd.Bitfield.SetBit(nd.Bitfield, s, 1)
+		}
+		for _, p := range ps {
+			nd.Pointers = append(nd.Pointers, &Pointer{Pointer: p})
+		}
+		nd.count = count
+		return nd
+	}
+	link := func(c cid.Cid) *pb.Pointer {
+		p := new(pb.Pointer)
+		p.SetLink(c)
+		return p
+	}
+
+	a := slotOf("a")
+	leaf := put(node(1, []int{slotOf("b")}, &pb.Pointer{Kvs: []*pb.KV{kv("b")}}))
+	missing := merkledag.NewRawNode([]byte("missing")).Cid()
+
+	// find keys that share the first slot without colliding completely
+	var same []string
+	for i := 0; len(same) < 5; i++ {
+		if k := fmt.Sprintf("k%d", i); slotOf(k) == a {
+			same = append(same, k)
+		}
+	}
+	var sameKvs []*pb.KV
+	for _, k := range same {
+		sameKvs = append(sameKvs, kv(k))
+	}
+
+	cases := []struct {
+		name string
+		root *Node
+		kind ViolationKind
+	}{
+		{"missing root", nil, MissingBlock},
+		{"missing child", node(0, []int{a}, link(missing)), MissingBlock},
+		{"bitfield", node(1, []int{a, a + 1}, &pb.Pointer{Kvs: []*pb.KV{kv("a")}}), BitfieldMismatch},
+		{"empty pointer", node(0, []int{a}, &pb.Pointer{}), EmptyPointer},
+		{"link and kvs", node(2, []int{a}, &pb.Pointer{LinkBits: leaf.Bytes(), Kvs: []*pb.KV{kv("a")}}), LinkAndKvs},
+		{"invalid link", node(0, []int{a}, &pb.Pointer{LinkBits: []byte{0xff}}), InvalidLink},
+		{"unsorted", node(2, []int{a}, &pb.Pointer{Kvs: []*pb.KV{sameKvs[1], sameKvs[0]}}), UnsortedBucket},
+		{"duplicate", node(2, []int{a}, &pb.Pointer{Kvs: []*pb.KV{kv("a"), kv("a")}}), DuplicateKey},
+		{"oversized", node(5, []int{a}, &pb.Pointer{Kvs: sameKvs}), OversizedBucket},
+		{"misplaced", node(1, []int{(a + 1) % 256}, &pb.Pointer{Kvs: []*pb.KV{kv("a")}}), MisplacedKey},
+		{"non-canonical", node(1, []int{slotOf("b")}, link(leaf)), NonCanonicalShard},
+		{"count", node(7, []int{a}, &pb.Pointer{Kvs: []*pb.KV{kv("a")}}), CountMismatch},
+	}
+
+	for _, tc := range cases {
+		root := missing
+		if tc.root != nil {
+			root = put(tc.root)
+		}
+		violations, err := Validate(ctx, cs, root)
+		if err != nil {
+			t.Fatal(err)
+		}
+		found := false
+		for _, v := range violations {
+			if v.Kind == tc.kind && v.Cid.Defined() {
+				found = true
+			}
+		}
+		if !found {
+			t.Fatalf("%s: expected a %s, got %v", tc.name, tc.kind, violations)
+		}
+	}
+}
+
+func TestValidateMissingValues(t *testing.T) {
+	ctx := context.Background()
+	cs := NewCborStore()
+	opts := []Option{UseValueThreshold(16), UseValueChunkSize(64)}
+
+	n := NewNode(cs, opts...)
+	for k, size := range map[string]int{"single": 50, "chunked": 500, "inline": 10} {
+		if err := n.SetRaw(ctx, k, make([]byte, size)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := n.Flush(ctx); err != nil {
+		t.Fatal(err)
+	}
+	root, err := cs.Put(ctx, n)
+	if err != nil {
+		t.Fatal(err)
+	}
+	violations, err := Validate(ctx, cs, root, opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(violations) != 0 {
+		t.Fatalf("expected no violations, got %v", violations)
+	}
+
+	links := map[string]cid.Cid{}
+	if err := n.ForEach(ctx, func(kv *pb.KV) error {
+		links[kv.Key] = kv.ValueCid()
+		return nil
+	}, KeepValueLinks()); err != nil {
+		t.Fatal(err)
+	}
+	chunks, err := cs.Nodes.Get(ctx, links["chunked"])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := cs.Nodes.(format.DAGService).Remove(ctx, links["single"]); err != nil {
+		t.Fatal(err)
+	}
+	if err := cs.Nodes.(format.DAGService).Remove(ctx, chunks.Links()[3].Cid); err != nil {
+		t.Fatal(err)
+	}
+
+	violations, err = Validate(ctx, cs, root, opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(violations) != 2 {
+		t.Fatalf("expected 2 violations, got %v", violations)
+	}
+	for _, v := range violations {
+		if v.Kind != MissingBlock || !v.Cid.Equals(root) {
+			t.Fatalf("expected a MissingBlock in the root, got %v", v)
+		}
+		k := "single"
+		if strings.Contains(v.Detail, `"chunked"`) {
+			k = "chunked"
+		}
+		if len(v.Path) != 1 || v.Path[0] != slotOf(k) {
+			t.Fatalf("%s: expected the path to its bucket, got %v", v.Detail, v.Path)
+		}
+	}
+}
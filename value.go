@@ -0,0 +1,213 @@
+package hamt
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+
+	cid "github.com/ipfs/go-cid"
+	"github.com/ipfs/go-merkledag"
+	"github.com/quorumcontrol/go-hamt-ipld/pb"
+)
+
+// defaultValueChunkSize is the largest block an externalized value is
+// stored in when no UseValueChunkSize option is given.
+const defaultValueChunkSize = 256 << 10
+
+// UseValueThreshold stores values larger than threshold bytes in blocks of
+// their own, linked from their pair, rather than inline in the node. This
+// keeps nodes small when some values are large. A threshold of 0, the
+// default, keeps every value inline. threshold must not be negative.
+func UseValueThreshold(threshold int) Option {
+	if threshold < 0 {
+		panic(fmt.Sprintf("invalid HAMT value threshold %d", threshold))
+	}
+	return func(c *config) {
+		c.valueThreshold = threshold
+	}
+}
+
+// UseValueChunkSize sets the largest block a value stored outside of its
+// node is written as. Larger values are split into raw blocks of this size,
+// linked in order from a dag-pb node. size must be at least 1.
+func UseValueChunkSize(size int) Option {
+	if size < 1 {
+		panic(fmt.Sprintf("invalid HAMT value chunk size %d", size))
+	}
+	return func(c *config) {
+		c.valueChunkSize = size
+	}
+}
+
+// newKV returns a pair for k holding data, encoded with the codec code. If
+// data is over the value threshold it is written to the store, and the pair
+// links to it instead.
+func (n *Node) newKV(ctx context.Context, k string, data []byte, code uint64) (*pb.KV, error) {
+	return externalize(ctx, n.store, n.conf(), newKV(k, data, code))
+}
+
+// externalize returns kv with its value moved to the store if it is over
+// the value threshold, or kv itself if it isn't.
+func externalize(ctx context.Context, cs *CborIpldStore, conf *config, kv *pb.KV) (*pb.KV, error) {
+	if conf.valueThreshold == 0 || len(kv.Value) <= conf.valueThreshold {
+		return kv, nil
+	}
+
+	c, err := cs.putValue(ctx, kv.Value, conf.valueChunkSize)
+	if err != nil {
+		return nil, err
+	}
+	return &pb.KV{Key: kv.Key, Codec: kv.Codec, ValueLink: c.Bytes()}, nil
+}
+
+// resolveKV returns kv with its value read back from the store if it isn't
+// inline.
+func (n *Node) resolveKV(ctx context.Context, kv *pb.KV) (*pb.KV, error) {
+	if len(kv.ValueLink) == 0 {
+		return kv, nil
+	}
+
+	r, err := n.store.valueReader(ctx, kv.ValueCid(), n.conf().limits)
+	if err != nil {
+		return nil, err
+	}
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	return &pb.KV{Key: kv.Key, Value: data, Codec: kv.Codec}, nil
+}
+
+// ValueReader returns a reader for the encoded value of k. Values stored
+// outside of their node are read from the store a block at a time as the
+// reader is consumed, so that very large values never have to be held in
+// memory whole.
+func (n *Node) ValueReader(ctx context.Context, k string) (io.Reader, error) {
+	var kv *pb.KV
+	err := n.getValue(ctx, &hashBits{b: n.hashKey(k)}, k, func(found *pb.KV) error {
+		kv = found
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if len(kv.ValueLink) == 0 {
+		return bytes.NewReader(kv.Value), nil
+	}
+	return n.store.valueReader(ctx, kv.ValueCid(), n.conf().limits)
+}
+
+// putValue writes data as a raw block, or if it is larger than chunkSize as
+// raw chunks linked from a dag-pb node, and returns the CID of the block to
+// read it back from.
+func (s *CborIpldStore) putValue(ctx context.Context, data []byte, chunkSize int) (cid.Cid, error) {
+	if len(data) <= chunkSize {
+		nd := merkledag.NewRawNode(data)
+		if err := s.Nodes.Add(ctx, nd); err != nil {
+			return cid.Undef, err
+		}
+		return nd.Cid(), nil
+	}
+
+	root := merkledag.NodeWithData(nil)
+	for len(data) > 0 {
+		l := chunkSize
+		if l > len(data) {
+			l = len(data)
+		}
+		chunk := merkledag.NewRawNode(data[:l])
+		if err := s.Nodes.Add(ctx, chunk); err != nil {
+			return cid.Undef, err
+		}
+		if err := root.AddNodeLink("", chunk); err != nil {
+			return cid.Undef, err
+		}
+		data = data[l:]
+	}
+	if err := s.Nodes.Add(ctx, root); err != nil {
+		return cid.Undef, err
+	}
+	return root.Cid(), nil
+}
+
+// valueReader returns a reader for a value written by putValue. With
+// limits, every block of the value is checked against MaxBlockSize, and the
+// reader fails once the value grows past MaxValueLength.
+func (s *CborIpldStore) valueReader(ctx context.Context, c cid.Cid, limits *DecodeLimits) (io.Reader, error) {
+	nd, err := s.Nodes.Get(ctx, c)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkValueBlock(c, nd.RawData(), limits); err != nil {
+		return nil, err
+	}
+
+	switch c.Type() {
+	case cid.Raw:
+		if limits != nil && limits.MaxValueLength > 0 && len(nd.RawData()) > limits.MaxValueLength {
+			return nil, &LimitError{Cid: c, Limit: "value length", Size: len(nd.RawData()), Max: limits.MaxValueLength}
+		}
+		return bytes.NewReader(nd.RawData()), nil
+	case cid.DagProtobuf:
+		root, err := merkledag.DecodeProtobuf(nd.RawData())
+		if err != nil {
+			return nil, err
+		}
+		return &chunkReader{ctx: ctx, store: s, root: c, chunks: linkCids(root.Links()), limits: limits}, nil
+	default:
+		return nil, fmt.Errorf("unexpected value block type %d", c.Type())
+	}
+}
+
+// chunkReader reads a value that is split over several raw blocks, loading
+// one block at a time.
+type chunkReader struct {
+	ctx    context.Context
+	store  *CborIpldStore
+	root   cid.Cid
+	chunks []cid.Cid
+	cur    []byte
+	// read is the length of the chunks loaded so far
+	read   int
+	limits *DecodeLimits
+}
+
+func (r *chunkReader) Read(p []byte) (int, error) {
+	for len(r.cur) == 0 {
+		if len(r.chunks) == 0 {
+			return 0, io.EOF
+		}
+		if r.chunks[0].Type() != cid.Raw {
+			return 0, fmt.Errorf("unexpected value chunk type %d", r.chunks[0].Type())
+		}
+		nd, err := r.store.Nodes.Get(r.ctx, r.chunks[0])
+		if err != nil {
+			return 0, err
+		}
+		if err := checkValueBlock(r.chunks[0], nd.RawData(), r.limits); err != nil {
+			return 0, err
+		}
+		r.read += len(nd.RawData())
+		if l := r.limits; l != nil && l.MaxValueLength > 0 && r.read > l.MaxValueLength {
+			return 0, &LimitError{Cid: r.root, Limit: "value length", Size: r.read, Max: l.MaxValueLength}
+		}
+		r.cur = nd.RawData()
+		r.chunks = r.chunks[1:]
+	}
+
+	l := copy(p, r.cur)
+	r.cur = r.cur[l:]
+	return l, nil
+}
+
+// checkValueBlock checks data, the block c of an externalized value, against
+// the MaxBlockSize of limits if there are any.
+func checkValueBlock(c cid.Cid, data []byte, limits *DecodeLimits) error {
+	if limits != nil && limits.MaxBlockSize > 0 && len(data) > limits.MaxBlockSize {
+		return &LimitError{Cid: c, Limit: "block size", Size: len(data), Max: limits.MaxBlockSize}
+	}
+	return nil
+}
@@ -0,0 +1,192 @@
+package hamt
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"math/rand"
+	"testing"
+	"testing/iotest"
+
+	"github.com/quorumcontrol/go-hamt-ipld/pb"
+)
+
+func TestExternalValues(t *testing.T) {
+	ctx := context.Background()
+	cs := NewCborStore()
+	opts := []Option{UseValueThreshold(100), UseValueChunkSize(1000)}
+
+	r := rand.New(rand.NewSource(1))
+	vals := map[string][]byte{}
+	for i, size := range []int{10, 100, 101, 1000, 1001, 5500} {
+		v := make([]byte, size)
+		r.Read(v)
+		vals[fmt.Sprintf("key%d", i)] = v
+	}
+
+	n := NewNode(cs, opts...)
+	for k, v := range vals {
+		if err := n.SetRaw(ctx, k, v); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := n.Set(ctx, "cbor", string(vals["key5"])); err != nil {
+		t.Fatal(err)
+	}
+	if err := n.Flush(ctx); err != nil {
+		t.Fatal(err)
+	}
+	c, err := cs.Put(ctx, n)
+	if err != nil {
+		t.Fatal(err)
+	}
+	loaded, err := LoadNode(ctx, cs, c, opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the pairs as stored link to large values
+	err = loaded.ForEach(ctx, func(kv *pb.KV) error {
+		if kv.Key == "cbor" {
+			if len(kv.ValueLink) == 0 {
+				return fmt.Errorf("expected the large CBOR value to be stored outside of the node")
+			}
+			return nil
+		}
+		external := len(vals[kv.Key]) > 100
+		if external != (len(kv.ValueLink) > 0) || external != (len(kv.Value) == 0) {
+			return fmt.Errorf("value of %s with %d bytes stored wrongly", kv.Key, len(vals[kv.Key]))
+		}
+		return nil
+	}, KeepValueLinks())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// every bulk read returns the values themselves, as GetKV does
+	pairs, err := loaded.AllPairs(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pairs) != len(vals)+1 {
+		t.Fatalf("expected %d pairs, got %d", len(vals)+1, len(pairs))
+	}
+	page, _, err := loaded.Page(ctx, nil, len(pairs))
+	if err != nil {
+		t.Fatal(err)
+	}
+	it := loaded.Iterator(ctx)
+	for i, kv := range pairs {
+		if !it.Next() {
+			t.Fatalf("iterator ended early: %v", it.Err())
+		}
+		got, err := loaded.GetKV(ctx, kv.Key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !kv.Equals(got) || !page[i].Equals(got) || !it.KV().Equals(got) || !bytes.Equal(it.Value(), got.Value) {
+			t.Fatalf("%s: bulk reads should resolve the value", kv.Key)
+		}
+	}
+
+	for k, v := range vals {
+		raw, err := loaded.FindRaw(ctx, k)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(raw, v) {
+			t.Fatalf("%s: value did not round trip", k)
+		}
+
+		kv, err := loaded.GetKV(ctx, k)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(kv.Value, v) || len(kv.ValueLink) != 0 {
+			t.Fatalf("%s: GetKV should resolve the value", k)
+		}
+
+		rd, err := loaded.ValueReader(ctx, k)
+		if err != nil {
+			t.Fatal(err)
+		}
+		streamed, err := ioutil.ReadAll(iotest.OneByteReader(rd))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(streamed, v) {
+			t.Fatalf("%s: streamed value differs", k)
+		}
+	}
+
+	s, err := loaded.Find(ctx, "cbor")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.(string) != string(vals["key5"]) {
+		t.Fatal("large CBOR value did not round trip")
+	}
+	if _, err := loaded.ValueReader(ctx, "missing"); err != ErrNotFound {
+		t.Fatalf("expected ErrNotFound, got %v", err)
+	}
+}
+
+func TestExternalValuesKeepNodesSmall(t *testing.T) {
+	ctx := context.Background()
+
+	big := make([]byte, 100000)
+	size := func(opts ...Option) int {
+		n := NewNode(NewCborStore(), opts...)
+		for i := 0; i < 3; i++ {
+			if err := n.SetRaw(ctx, fmt.Sprintf("key%d", i), big); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := n.Flush(ctx); err != nil {
+			t.Fatal(err)
+		}
+		st, err := n.Stats(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return st.BlockSizes.Total
+	}
+
+	if inline, external := size(), size(UseValueThreshold(1024)); external > 1024 || inline < 300000 {
+		t.Fatalf("expected a small root with external values, got %d bytes (inline %d)", external, inline)
+	}
+}
+
+func TestBuildExternalValues(t *testing.T) {
+	ctx := context.Background()
+	cs := NewCborStore()
+	opts := []Option{UseValueThreshold(50), UseValueChunkSize(64)}
+
+	r := rand.New(rand.NewSource(2))
+	var kvs []*pb.KV
+	for i := 0; i < 300; i++ {
+		v := make([]byte, r.Intn(200))
+		r.Read(v)
+		kvs = append(kvs, &pb.KV{Key: fmt.Sprintf("key%d", i), Value: v})
+	}
+
+	incremental := NewNode(cs, opts...)
+	for _, kv := range kvs {
+		if err := incremental.SetRaw(ctx, kv.Key, kv.Value); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var raw []*pb.KV
+	for _, kv := range kvs {
+		raw = append(raw, newKV(kv.Key, kv.Value, RawCodec.Code()))
+	}
+	built, err := Build(ctx, cs, &sliceSource{kvs: raw}, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !nodesEqual(t, cs, incremental, built) {
+		t.Fatal("built HAMT differs from incremental insertion")
+	}
+}
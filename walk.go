@@ -0,0 +1,163 @@
+package hamt
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+
+	"github.com/gogo/protobuf/proto"
+	ptypes "github.com/gogo/protobuf/types"
+	blocks "github.com/ipfs/go-block-format"
+	cid "github.com/ipfs/go-cid"
+	cbor "github.com/ipfs/go-ipld-cbor"
+	format "github.com/ipfs/go-ipld-format"
+	"github.com/ipfs/go-merkledag"
+	"github.com/quorumcontrol/go-hamt-ipld/goipldpb"
+)
+
+// blockGetter reads the block c from wherever a walk is reading from.
+type blockGetter func(ctx context.Context, c cid.Cid) (blocks.Block, error)
+
+// walkBlocks calls visit with every block reachable from roots, once each,
+// depth first and every block before the blocks it links to. Values stored
+// outside of their node are always followed; links inside CBOR values only if
+// followValueLinks is set.
+func walkBlocks(ctx context.Context, get blockGetter, roots []cid.Cid, followValueLinks bool, visit func(blocks.Block) error) error {
+	seen := cid.NewSet()
+	stack := make([]cid.Cid, len(roots))
+	for i, c := range roots {
+		stack[len(roots)-1-i] = c
+	}
+
+	for len(stack) > 0 {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		c := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		if !seen.Visit(c) {
+			continue
+		}
+
+		blk, err := get(ctx, c)
+		if err != nil {
+			return fmt.Errorf("error getting %s: %v", c, err)
+		}
+		if err := visit(blk); err != nil {
+			return err
+		}
+
+		links, err := blockLinks(ctx, get, blk, followValueLinks)
+		if err != nil {
+			return fmt.Errorf("error reading links of %s: %v", c, err)
+		}
+		for i := len(links) - 1; i >= 0; i-- {
+			stack = append(stack, links[i])
+		}
+	}
+	return nil
+}
+
+// hamtMessageName is the name HAMT nodes are stored under in the Any that
+// wraps them.
+var hamtMessageName = proto.MessageName(new(Node))
+
+// blockLinks returns the CIDs blk links to, in order. Values stored outside
+// of their node are read with get when their links are followed.
+func blockLinks(ctx context.Context, get blockGetter, blk blocks.Block, followValueLinks bool) ([]cid.Cid, error) {
+	switch blk.Cid().Type() {
+	case cid.Raw:
+		return nil, nil
+	case cid.DagCBOR:
+		nd, err := cbor.DecodeBlock(blk)
+		if err != nil {
+			return nil, err
+		}
+		return linkCids(nd.Links()), nil
+	case cid.DagProtobuf:
+		pn, err := merkledag.DecodeProtobuf(blk.RawData())
+		if err != nil {
+			return nil, err
+		}
+		// the dag-pb nodes linking the chunks of large values have no data
+		if len(pn.Data()) > 0 {
+			any := new(ptypes.Any)
+			if err := any.Unmarshal(pn.Data()); err == nil {
+				if name, err := goipldpb.AnyMessageName(any); err == nil && name == hamtMessageName {
+					return nodeLinks(ctx, get, any.Value, followValueLinks)
+				}
+			}
+		}
+		return linkCids(pn.Links()), nil
+	default:
+		return nil, fmt.Errorf("unsupported block type %d", blk.Cid().Type())
+	}
+}
+
+// nodeLinks returns the CIDs the encoded HAMT node data links to.
+func nodeLinks(ctx context.Context, get blockGetter, data []byte, followValueLinks bool) ([]cid.Cid, error) {
+	var nd Node
+	if err := nd.Unmarshal(data); err != nil {
+		return nil, err
+	}
+
+	var links []cid.Cid
+	for _, p := range nd.Pointers {
+		if p.isShard() {
+			links = append(links, p.Link())
+			continue
+		}
+		for _, kv := range p.Kvs {
+			value := kv.Value
+			if len(kv.ValueLink) > 0 {
+				links = append(links, kv.ValueCid())
+				if !followValueLinks || codecOf(kv) != cid.DagCBOR {
+					continue
+				}
+				// read the value back, from all of its chunks, to find the
+				// links inside it
+				store := &CborIpldStore{Nodes: blockNodes{get: get}}
+				r, err := store.valueReader(ctx, kv.ValueCid(), nil)
+				if err != nil {
+					return nil, err
+				}
+				if value, err = ioutil.ReadAll(r); err != nil {
+					return nil, err
+				}
+			}
+			if followValueLinks && codecOf(kv) == cid.DagCBOR {
+				v, err := cbor.Decode(value, mhType, mhLen)
+				if err != nil {
+					return nil, err
+				}
+				links = append(links, linkCids(v.Links())...)
+			}
+		}
+	}
+	return links, nil
+}
+
+// blockNodes reads the nodes of a value through the blockGetter of a walk.
+type blockNodes struct {
+	get blockGetter
+}
+
+func (b blockNodes) Get(ctx context.Context, c cid.Cid) (format.Node, error) {
+	blk, err := b.get(ctx, c)
+	if err != nil {
+		return nil, err
+	}
+	return format.Decode(blk)
+}
+
+func (b blockNodes) Add(context.Context, format.Node) error {
+	return fmt.Errorf("can't add nodes during a walk")
+}
+
+func linkCids(links []*format.Link) []cid.Cid {
+	out := make([]cid.Cid, len(links))
+	for i, l := range links {
+		out[i] = l.Cid
+	}
+	return out
+}